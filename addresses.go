@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+// How often the wallet is asked for our addresses again, to pick up
+// any made outside of gembot.
+const addressRefresh = 10 * time.Minute
+
+// newAddress asks the wallet for a new address with the given label.
+// It's a variable so tests can fake it.
+var newAddress = func(label string) (string, error) {
+	var addr string
+	err := callBitcoind("getnewaddress", &addr, label)
+	return addr, err
+}
+
+func addMyAddress(a string) {
+	myAddressesMu.Lock()
+	defer myAddressesMu.Unlock()
+
+	myAddresses[a] = true
+}
+
+// moveFunds moves money between accounts within our wallet.
+var moveFunds = func(from, to string, amt bitcoin.Amount) error {
+	defer timeRPC("move")()
+	return callBitcoind("move", nil, from, to, btc(amt))
+}
+
+// freshPrefix starts the label of every fresh address for the named
+// site.
+func freshPrefix(site string) string {
+	return "gembot " + site + " "
+}
+
+// freshLabel is the label a new receive address is given for a
+// purchase from the named site at the given time.
+func freshLabel(site string, t time.Time) string {
+	return freshPrefix(site) + t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// receiveAddress is where the site should pay us if we buy now.
+// Fresh addresses are recognized as ours straight away, without
+// waiting for the next updateMyAddresses.
+func (s *site) receiveAddress(t time.Time) (string, error) {
+	if !s.FreshAddress {
+		return s.RecvAddress, nil
+	}
+	a, err := newAddress(freshLabel(s.name(), t))
+	if err != nil {
+		return "", err
+	}
+	addMyAddress(a)
+	log.Printf("Using new address %v for %v", a, s.ReadURL)
+	return a, nil
+}
+
+// sweepProceeds moves what's been paid to the sites' fresh addresses
+// into their FromAcct.  Labels are accounts to our wallet, so
+// otherwise the proceeds of a sale sit where SendFrom can't spend
+// them.  Callers must hold feeMu, so two purchases can't both move the
+// same balance.
+func sweepProceeds(sites []site) error {
+	into := map[string]string{}
+	for i := range sites {
+		s := &sites[i]
+		if s.FreshAddress && s.FromAcct != "" {
+			into[freshPrefix(s.name())] = s.FromAcct
+		}
+	}
+	if len(into) == 0 {
+		return nil
+	}
+
+	accts, err := listAccounts()
+	if err != nil {
+		return err
+	}
+	for acct, bal := range accts {
+		// Everything after the prefix is the time, with no spaces.
+		to, ok := into[acct[:strings.LastIndex(acct, " ")+1]]
+		if !ok || bal <= 0 {
+			continue
+		}
+		if err := moveFunds(acct, to, bal); err != nil {
+			return err
+		}
+		log.Printf("Moved %v from %q to %q", bal, acct, to)
+	}
+	return nil
+}
+
+// refreshAddresses keeps myAddresses current, so a payment to an
+// address added to the wallet after startup is still seen as ours.
+func refreshAddresses() {
+	for range time.Tick(addressRefresh) {
+		if err := updateMyAddresses(); err != nil {
+			log.Printf("Error updating my addresses: %v", err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+func TestReceiveAddress(t *testing.T) {
+	prev, prevMine := newAddress, myAddresses
+	defer func() { newAddress, myAddresses = prev, prevMine }()
+	myAddresses = map[string]bool{}
+
+	var labels []string
+	newAddress = func(label string) (string, error) {
+		labels = append(labels, label)
+		return "1Fresh", nil
+	}
+
+	now := time.Date(2013, 5, 1, 12, 30, 0, 0, time.UTC)
+	s := &site{Name: "bears", RecvAddress: "1Static"}
+	if a, err := s.receiveAddress(now); err != nil || a != "1Static" {
+		t.Errorf("Expected the static address, got %v/%v", a, err)
+	}
+	if isMyAddress("paid to 1Fresh") {
+		t.Errorf("Didn't expect the fresh address to be mine yet")
+	}
+
+	s.FreshAddress = true
+	if a, err := s.receiveAddress(now); err != nil || a != "1Fresh" {
+		t.Errorf("Expected a fresh address, got %v/%v", a, err)
+	}
+	if len(labels) != 1 || labels[0] != "gembot bears 2013-05-01T12:30:00Z" {
+		t.Errorf("Expected a labelled address, got %v", labels)
+	}
+	if !isMyAddress("paid to 1Fresh") {
+		t.Errorf("Expected the fresh address to be mine")
+	}
+}
+
+func TestFreshAddressProceeds(t *testing.T) {
+	prevNew, prevList, prevMove, prevMine := newAddress, listAccounts, moveFunds, myAddresses
+	defer func() {
+		newAddress, listAccounts, moveFunds, myAddresses = prevNew, prevList, prevMove, prevMine
+	}()
+	myAddresses = map[string]bool{}
+
+	// A wallet where every label is an account.
+	wallet := map[string]bitcoin.Amount{"trading": 1e8, "gembot bears two 2013-04-01T00:00:00Z": 5}
+	byAddr := map[string]string{}
+	newAddress = func(label string) (string, error) {
+		a := "1" + label
+		wallet[label] += 0
+		byAddr[a] = label
+		return a, nil
+	}
+	listAccounts = func() (map[string]bitcoin.Amount, error) {
+		rv := map[string]bitcoin.Amount{}
+		for k, v := range wallet {
+			rv[k] = v
+		}
+		return rv, nil
+	}
+	moveFunds = func(from, to string, amt bitcoin.Amount) error {
+		wallet[from] -= amt
+		wallet[to] += amt
+		return nil
+	}
+	sendFrom := func(acct string, amt bitcoin.Amount) bool {
+		if wallet[acct] < amt {
+			return false
+		}
+		wallet[acct] -= amt
+		return true
+	}
+
+	sites := []site{{Name: "bears", FreshAddress: true, FromAcct: "trading"}}
+	s := &sites[0]
+	buy := func(amt bitcoin.Amount, when time.Time) (string, bool) {
+		a, err := s.receiveAddress(when)
+		if err != nil {
+			t.Fatalf("Error getting an address: %v", err)
+		}
+		if err := sweepProceeds(sites); err != nil {
+			t.Fatalf("Error sweeping proceeds: %v", err)
+		}
+		return a, sendFrom(s.FromAcct, amt)
+	}
+
+	start := time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)
+	addr, ok := buy(1e8, start)
+	if !ok {
+		t.Fatalf("Expected the first purchase to be funded, wallet: %v", wallet)
+	}
+
+	// Someone buys it from us, paying to the fresh address.
+	wallet[byAddr[addr]] += 15e7
+
+	if _, ok := buy(12e7, start.Add(time.Hour)); !ok {
+		t.Fatalf("Expected the proceeds to fund the next purchase, wallet: %v", wallet)
+	}
+	if wallet["trading"] != 3e7 || wallet[byAddr[addr]] != 0 {
+		t.Errorf("Expected the proceeds moved to trading, wallet: %v", wallet)
+	}
+	if wallet["gembot bears two 2013-04-01T00:00:00Z"] != 5 {
+		t.Errorf("Expected another site's proceeds left alone, wallet: %v", wallet)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	rolePublic = iota
+	roleRead
+	roleAdmin
+)
+
+var roles = map[string]int{
+	"read":  roleRead,
+	"admin": roleAdmin,
+}
+
+// httpConf secures the HTTP server.  With no users configured,
+// everything is open as it always has been.
+type httpConf struct {
+	Cert  string
+	Key   string
+	Users []httpUser
+}
+
+// An httpUser authenticates with either basic auth or a bearer
+// token.  Role is read or admin.
+type httpUser struct {
+	Name     string
+	Password string
+	Token    string
+	Role     string
+}
+
+func (c httpConf) validate() error {
+	if (c.Cert == "") != (c.Key == "") {
+		return fmt.Errorf("TLS needs both a cert and a key")
+	}
+	for _, u := range c.Users {
+		if _, ok := roles[u.Role]; !ok {
+			return fmt.Errorf("unknown role %q for %q", u.Role, u.Name)
+		}
+		if u.Password == "" && u.Token == "" {
+			return fmt.Errorf("no password or token for %q", u.Name)
+		}
+	}
+	return nil
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// authenticate finds the role of the requester, or false if they
+// didn't authenticate.
+func (c httpConf) authenticate(req *http.Request) (int, bool) {
+	if name, pass, ok := req.BasicAuth(); ok {
+		for _, u := range c.Users {
+			if u.Password != "" && secureEqual(u.Name, name) && secureEqual(u.Password, pass) {
+				return roles[u.Role], true
+			}
+		}
+		return rolePublic, false
+	}
+
+	if h := req.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		tok := strings.TrimSpace(h[len("Bearer "):])
+		for _, u := range c.Users {
+			if u.Token != "" && secureEqual(u.Token, tok) {
+				return roles[u.Role], true
+			}
+		}
+	}
+	return rolePublic, false
+}
+
+// permits reports whether a request has at least the given role.
+// Everyone does when no users are configured.
+func (c httpConf) permits(req *http.Request, role int) bool {
+	if role == rolePublic || len(c.Users) == 0 {
+		return true
+	}
+	got, ok := c.authenticate(req)
+	return ok && got >= role
+}
+
+// authorize wraps a handler to require at least the given role.
+func (c httpConf) authorize(role int, h http.HandlerFunc) http.HandlerFunc {
+	if role == rolePublic || len(c.Users) == 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		got, ok := c.authenticate(req)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gembot"`)
+			http.Error(w, "authentication required", 401)
+			return
+		}
+		if got < role {
+			http.Error(w, "forbidden", 403)
+			return
+		}
+		h(w, req)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	c := httpConf{Users: []httpUser{
+		{Name: "viewer", Password: "pw", Role: "read"},
+		{Name: "boss", Password: "secret", Role: "admin"},
+		{Name: "script", Token: "tok", Role: "read"},
+	}}
+	if err := c.validate(); err != nil {
+		t.Fatalf("Invalid config: %v", err)
+	}
+
+	ok := func(w http.ResponseWriter, req *http.Request) {}
+
+	tests := []struct {
+		role  int
+		user  string
+		pass  string
+		token string
+		exp   int
+	}{
+		{roleRead, "", "", "", 401},
+		{roleRead, "viewer", "pw", "", 200},
+		{roleRead, "viewer", "wrong", "", 401},
+		{roleRead, "", "", "tok", 200},
+		{roleRead, "", "", "nope", 401},
+		{roleAdmin, "viewer", "pw", "", 403},
+		{roleAdmin, "", "", "tok", 403},
+		{roleAdmin, "boss", "secret", "", 200},
+		{rolePublic, "", "", "", 200},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.user != "" {
+			req.SetBasicAuth(test.user, test.pass)
+		}
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		c.authorize(test.role, ok)(w, req)
+		if w.Code != test.exp {
+			t.Errorf("Expected %v for %+v, got %v", test.exp, test, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	httpConf{}.authorize(roleAdmin, ok)(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 200 {
+		t.Errorf("Expected no auth without users, got %v", w.Code)
+	}
+}
+
+func TestHTTPConfValidation(t *testing.T) {
+	bad := []httpConf{
+		{Cert: "cert.pem"},
+		{Users: []httpUser{{Name: "x", Password: "y", Role: "god"}}},
+		{Users: []httpUser{{Name: "x", Role: "read"}}},
+	}
+	for _, c := range bad {
+		if err := c.validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", c)
+		}
+	}
+}
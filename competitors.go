@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxReaction = 30 * time.Second
+	defaultMinWins     = 3
+	defaultBotRatio    = 0.75
+	competitorRefresh  = 5 * time.Minute
+)
+
+// How a site reacts when a likely bot keeps winning it.
+const (
+	onBotYield   = "yield"
+	onBotCompete = "compete"
+)
+
+// competitorConf decides who looks like a bot.  An owner is a bot
+// once they've won at least MinWins sites with a measurable reaction
+// time and at least Ratio of those took no more than MaxReaction
+// after the site became available.
+type competitorConf struct {
+	MaxReaction duration `json:"max_reaction"`
+	MinWins     int      `json:"min_wins"`
+	Ratio       float64  `json:"ratio"`
+}
+
+func (c competitorConf) withDefaults() competitorConf {
+	if c.MaxReaction == 0 {
+		c.MaxReaction = duration(defaultMaxReaction)
+	}
+	if c.MinWins == 0 {
+		c.MinWins = defaultMinWins
+	}
+	if c.Ratio == 0 {
+		c.Ratio = defaultBotRatio
+	}
+	return c
+}
+
+// A competitor is everything we've seen of one other owner.  Reaction
+// times are upper bounds as they're only as good as our polling.
+type competitor struct {
+	Name     string    `json:"name"`
+	Link     string    `json:"link,omitempty"`
+	Address  string    `json:"address,omitempty"`
+	Wins     int       `json:"wins"`
+	Timed    int       `json:"timed"`
+	Fast     int       `json:"fast"`
+	Median   duration  `json:"median_reaction"`
+	Sites    []string  `json:"sites"`
+	LastWin  time.Time `json:"last_win"`
+	Bot      bool      `json:"bot"`
+	key      string
+	reaction []time.Duration
+}
+
+type acquisition struct {
+	site     string
+	owner    ownership
+	reaction time.Duration
+	timed    bool
+}
+
+// reactionTime finds how long after the site last became available
+// (unlocked or dropped in price) during prev's tenure it went to
+// next.
+func reactionTime(prev, next ownership, pts []pricePoint) (time.Duration, bool) {
+	var trigger time.Time
+	for i := 1; i < len(pts); i++ {
+		p := pts[i]
+		if p.Time.Before(prev.Since) || !p.Time.Before(next.Since) {
+			continue
+		}
+		if p.Locked {
+			continue
+		}
+		if pts[i-1].Locked || p.Value < pts[i-1].Value {
+			trigger = p.Time
+		}
+	}
+	if trigger.IsZero() {
+		return 0, false
+	}
+	return next.Since.Sub(trigger), true
+}
+
+func acquisitions(site string, hist []ownership, pts []pricePoint) []acquisition {
+	var rv []acquisition
+	for i := 1; i < len(hist); i++ {
+		if hist[i].Mine {
+			continue
+		}
+		a := acquisition{site: site, owner: hist[i]}
+		a.reaction, a.timed = reactionTime(hist[i-1], hist[i], pts)
+		rv = append(rv, a)
+	}
+	return rv
+}
+
+type competitorReport struct {
+	Updated     time.Time    `json:"updated"`
+	Competitors []competitor `json:"competitors"`
+	// Sites where the last few acquisitions all went to bots, and
+	// the most recent of them.
+	Contested map[string]string `json:"contested"`
+}
+
+func analyzeCompetitors(c competitorConf, acqs map[string][]acquisition) competitorReport {
+	c = c.withDefaults()
+	byKey := map[string]*competitor{}
+	for site, as := range acqs {
+		for _, a := range as {
+			k := a.owner.key()
+			comp := byKey[k]
+			if comp == nil {
+				comp = &competitor{key: k}
+				byKey[k] = comp
+			}
+			comp.Name, comp.Link, comp.Address = a.owner.Name, a.owner.Link, a.owner.Address
+			comp.Wins++
+			if a.owner.Since.After(comp.LastWin) {
+				comp.LastWin = a.owner.Since
+			}
+			if !containsString(comp.Sites, site) {
+				comp.Sites = append(comp.Sites, site)
+			}
+			if a.timed {
+				comp.Timed++
+				comp.reaction = append(comp.reaction, a.reaction)
+				if a.reaction <= time.Duration(c.MaxReaction) {
+					comp.Fast++
+				}
+			}
+		}
+	}
+
+	rv := competitorReport{Contested: map[string]string{}}
+	for _, comp := range byKey {
+		if len(comp.reaction) > 0 {
+			sort.Sort(byDuration(comp.reaction))
+			comp.Median = duration(comp.reaction[len(comp.reaction)/2])
+		}
+		sort.Strings(comp.Sites)
+		comp.Bot = comp.Timed >= c.MinWins &&
+			float64(comp.Fast) >= c.Ratio*float64(comp.Timed)
+		rv.Competitors = append(rv.Competitors, *comp)
+	}
+	sort.Sort(competitorsByWins(rv.Competitors))
+
+	for site, as := range acqs {
+		if len(as) < c.MinWins {
+			continue
+		}
+		recent := as[len(as)-c.MinWins:]
+		allBots := true
+		for _, a := range recent {
+			if comp := byKey[a.owner.key()]; comp == nil || !comp.Bot {
+				allBots = false
+			}
+		}
+		if allBots {
+			rv.Contested[site] = recent[len(recent)-1].owner.Name
+		}
+	}
+	return rv
+}
+
+func containsString(a []string, s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+type byDuration []time.Duration
+
+func (d byDuration) Len() int           { return len(d) }
+func (d byDuration) Less(i, j int) bool { return d[i] < d[j] }
+func (d byDuration) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+type competitorsByWins []competitor
+
+func (c competitorsByWins) Len() int { return len(c) }
+func (c competitorsByWins) Less(i, j int) bool {
+	if c[i].Bot != c[j].Bot {
+		return c[i].Bot
+	}
+	return c[i].Wins > c[j].Wins
+}
+func (c competitorsByWins) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+
+// competitorWatch keeps a recent analysis of all our sites around.
+// It's refreshed in the background so nothing reading it waits on
+// the disk.
+type competitorWatch struct {
+	mu     sync.Mutex
+	report competitorReport
+}
+
+var competitors = &competitorWatch{}
+
+func (w *competitorWatch) refresh(now time.Time) {
+	acqs := map[string][]acquisition{}
+	for i := range conf.Sites {
+		name := conf.Sites[i].name()
+		// Acquisitions from before the recent history go untimed.
+		pts, err := priceHistory.recent(name)
+		if err != nil {
+			log.Printf("Error reading history of %v: %v", name, err)
+		}
+		acqs[name] = acquisitions(name, owners.history(name), pts)
+	}
+	r := analyzeCompetitors(conf.Competitors, acqs)
+	r.Updated = now
+
+	w.mu.Lock()
+	prev := w.report
+	w.report = r
+	w.mu.Unlock()
+
+	// Only announce bots that are new since we started.
+	first := prev.Updated.IsZero()
+	known := map[string]bool{}
+	for _, c := range prev.Competitors {
+		known[c.key] = c.Bot
+	}
+	maxReaction := conf.Competitors.withDefaults().MaxReaction
+	for _, c := range r.Competitors {
+		if c.Bot && !known[c.key] && !first {
+			log.Printf("%v looks like a bot: %v of %v wins within %v",
+				c.Name, c.Fast, c.Timed, maxReaction)
+			postNotification(notification{
+				Event: "Likely bot: " + c.Name,
+				Msg: fmt.Sprintf("%v has taken %v of %v sites within %v (median %v) on %v",
+					c.Name, c.Fast, c.Timed, maxReaction, c.Median, c.Sites),
+				Severity: sevInfo,
+			})
+		}
+	}
+}
+
+func (w *competitorWatch) run() {
+	w.refresh(time.Now())
+	for now := range time.Tick(competitorRefresh) {
+		w.refresh(now)
+	}
+}
+
+func (w *competitorWatch) current() competitorReport {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.report
+}
+
+// contested returns the bot that keeps winning a site, if any.
+func (w *competitorWatch) contested(site string) string {
+	return w.current().Contested[site]
+}
+
+// eagerState is how we poll a site we'd buy as soon as we could.
+func (s *site) eagerState() int {
+	bot := competitors.contested(s.name())
+	if bot == "" {
+		return aggressive
+	}
+	switch s.OnBot {
+	case onBotYield:
+		return normal
+	case onBotCompete:
+		return sprint
+	}
+	return aggressive
+}
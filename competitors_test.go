@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReactionTime(t *testing.T) {
+	start := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+
+	prev := ownership{Name: "a", Since: at(0)}
+	next := ownership{Name: "b", Since: at(100)}
+
+	tests := []struct {
+		pts []pricePoint
+		exp time.Duration
+		ok  bool
+	}{
+		{nil, 0, false},
+		{[]pricePoint{{Time: at(0), Value: 10}, {Time: at(50), Value: 12}}, 0, false},
+		{[]pricePoint{{Time: at(0), Value: 10, Locked: true}, {Time: at(90), Value: 10}}, 10 * time.Second, true},
+		{[]pricePoint{{Time: at(0), Value: 10}, {Time: at(40), Value: 8}, {Time: at(70), Value: 7}}, 30 * time.Second, true},
+		// Unlocked before this owner's tenure doesn't count.
+		{[]pricePoint{{Time: at(-10), Value: 10, Locked: true}, {Time: at(-5), Value: 10}}, 0, false},
+		// Nor does anything after the new owner showed up.
+		{[]pricePoint{{Time: at(0), Value: 10, Locked: true}, {Time: at(100), Value: 10}}, 0, false},
+	}
+
+	for i, test := range tests {
+		got, ok := reactionTime(prev, next, test.pts)
+		if got != test.exp || ok != test.ok {
+			t.Errorf("%v: expected %v/%v, got %v/%v", i, test.exp, test.ok, got, ok)
+		}
+	}
+}
+
+func TestAnalyzeCompetitors(t *testing.T) {
+	start := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	win := func(name string, reaction time.Duration) acquisition {
+		start = start.Add(time.Hour)
+		return acquisition{
+			owner:    ownership{Name: name, Address: "1" + name, Since: start},
+			reaction: reaction,
+			timed:    reaction > 0,
+		}
+	}
+
+	acqs := map[string][]acquisition{
+		"a": {
+			win("human", 10*time.Minute),
+			win("bot", 3*time.Second),
+			win("bot", 5*time.Second),
+			win("bot", 2*time.Second),
+		},
+		"b": {
+			win("bot", 4*time.Second),
+			win("human", 0),
+			win("bot", 20*time.Minute),
+		},
+		"c": {
+			win("human", 5*time.Second),
+			win("human", 8*time.Second),
+		},
+	}
+
+	r := analyzeCompetitors(competitorConf{}, acqs)
+	if len(r.Competitors) != 2 {
+		t.Fatalf("Expected two competitors, got %+v", r.Competitors)
+	}
+	bot := r.Competitors[0]
+	if bot.Name != "bot" || !bot.Bot || bot.Wins != 5 || bot.Fast != 4 || bot.Timed != 5 {
+		t.Errorf("Expected bot to be a bot, got %+v", bot)
+	}
+	if bot.Median != duration(4*time.Second) {
+		t.Errorf("Expected a 4s median, got %v", bot.Median)
+	}
+	if len(bot.Sites) != 2 || bot.Sites[0] != "a" || bot.Sites[1] != "b" {
+		t.Errorf("Expected bot on a and b, got %v", bot.Sites)
+	}
+	if human := r.Competitors[1]; human.Bot || human.Timed != 3 {
+		t.Errorf("Expected human not to be a bot, got %+v", human)
+	}
+
+	if len(r.Contested) != 1 || r.Contested["a"] != "bot" {
+		t.Errorf("Expected only a to be contested, got %v", r.Contested)
+	}
+}
+
+func TestCompetitorWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "competitors")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldSites, oldOwners, oldHistory := conf.Sites, owners, priceHistory
+	defer func() { conf.Sites, owners, priceHistory = oldSites, oldOwners, oldHistory }()
+	conf.Sites = []site{{Name: "a"}}
+	owners = &ownerLog{Sites: map[string][]ownership{}}
+	priceHistory = &priceStore{dir: dir, tail: map[string][]pricePoint{}}
+
+	// Two bots trade the site five seconds after every unlock.
+	now := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 8; i++ {
+		name := "human"
+		if i > 0 {
+			name = fmt.Sprintf("bot%d", i%2)
+		}
+		st := State{Value: 100, Locked: true, OwnerName: name, OwnerAddress: name}
+		owners.observe("a", st, now)
+		priceHistory.observe("a", st, now)
+		now = now.Add(time.Hour)
+		st.Locked = false
+		priceHistory.observe("a", st, now)
+		now = now.Add(5 * time.Second)
+	}
+
+	w := &competitorWatch{}
+	if r := w.current(); !r.Updated.IsZero() || w.contested("a") != "" {
+		t.Errorf("Expected nothing before the first refresh, got %+v", r)
+	}
+
+	w.refresh(now)
+	if got := w.contested("a"); got != "bot1" {
+		t.Errorf("Expected a to be contested by bot1, got %q (%+v)", got, w.current())
+	}
+}
+
+func TestCompetitorNotification(t *testing.T) {
+	dir, err := ioutil.TempDir("", "competitors")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldSites, oldOwners, oldHistory := conf.Sites, owners, priceHistory
+	oldConf, oldOutbox := conf.Competitors, notifyOutbox
+	defer func() {
+		conf.Sites, owners, priceHistory = oldSites, oldOwners, oldHistory
+		conf.Competitors, notifyOutbox = oldConf, oldOutbox
+	}()
+	conf.Sites = []site{{Name: "a"}}
+	conf.Competitors = competitorConf{MaxReaction: duration(time.Minute)}
+	owners = &ownerLog{Sites: map[string][]ownership{}}
+	priceHistory = &priceStore{dir: dir, tail: map[string][]pricePoint{}}
+	notifyOutbox = &outbox{wake: map[string]chan bool{}}
+
+	// Everyone takes the site 50 seconds after it unlocks.
+	now := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 8; i++ {
+		name := "human"
+		if i%2 == 1 {
+			name = "bot"
+		}
+		st := State{Value: 100, Locked: true, OwnerName: name, OwnerAddress: name}
+		owners.observe("a", st, now)
+		priceHistory.observe("a", st, now)
+		now = now.Add(time.Hour)
+		st.Locked = false
+		priceHistory.observe("a", st, now)
+		now = now.Add(50 * time.Second)
+	}
+
+	// Only bots new since an earlier refresh are announced.
+	w := &competitorWatch{report: competitorReport{Updated: now.Add(-time.Hour)}}
+	w.refresh(now)
+	notes := notifyOutbox.takeIncoming()
+	if len(notes) == 0 || notes[0].Msg != "bot has taken 4 of 4 sites within 1m0s (median 50s) on [a]" {
+		t.Errorf("Expected the bot announced with the configured reaction, got %+v", notes)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday.
+	from := time.Date(2013, 5, 8, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		sched string
+		exp   string
+	}{
+		{"* * * * *", "2013-05-08T15:31:00Z"},
+		{"0 0 * * *", "2013-05-09T00:00:00Z"},
+		{"*/15 * * * *", "2013-05-08T15:45:00Z"},
+		{"30 15 * * *", "2013-05-09T15:30:00Z"},
+		{"0 9-17/4 * * *", "2013-05-08T17:00:00Z"},
+		{"0 0 1 * *", "2013-06-01T00:00:00Z"},
+		{"0 0 * * 0", "2013-05-12T00:00:00Z"},
+		{"0 0 * * 7", "2013-05-12T00:00:00Z"},
+		{"0 0 * * 1,5", "2013-05-10T00:00:00Z"},
+		{"0 0 1 * 5", "2013-05-10T00:00:00Z"},
+		{"0 0 29 2 *", "2016-02-29T00:00:00Z"},
+	}
+
+	for _, test := range tests {
+		c, err := parseCron(test.sched)
+		if err != nil {
+			t.Errorf("Error parsing %q: %v", test.sched, err)
+			continue
+		}
+		if got := c.next(from).Format(time.RFC3339); got != test.exp {
+			t.Errorf("Expected %v after %v for %q, got %v", test.exp, from, test.sched, got)
+		}
+	}
+}
+
+func TestCronErrors(t *testing.T) {
+	tests := []string{
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"x * * * *",
+	}
+
+	for _, test := range tests {
+		if _, err := parseCron(test); err == nil {
+			t.Errorf("Expected error parsing %q", test)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+const dashboardRefresh = 30
+
+type dashboardData struct {
+	Time          time.Time      `json:"time"`
+	Balance       bitcoin.Amount `json:"balance"`
+	BalanceTime   time.Time      `json:"balance_time"`
+	BalanceError  string         `json:"balance_error,omitempty"`
+	Sites         []siteStatus   `json:"sites"`
+	Trades        []trade        `json:"trades"`
+	Notifications []historyEntry `json:"notifications"`
+	Competitors   []competitor   `json:"competitors"`
+	Transactions  []watchedTx    `json:"transactions"`
+
+	Charts  map[string]template.HTML `json:"-"`
+	Refresh int                      `json:"-"`
+}
+
+func (d *dashboardData) drawCharts() {
+	d.Charts = map[string]template.HTML{}
+	for _, st := range d.Sites {
+		pts, err := priceHistory.recent(st.Name)
+		if err != nil {
+			log.Printf("Error reading history of %v: %v", st.Name, err)
+			continue
+		}
+		d.Charts[st.Name] = historyChart(pts, d.Time)
+	}
+}
+
+func currentStatus() dashboardData {
+	d := dashboardData{
+		Time:          time.Now(),
+		Sites:         siteStatuses.all(),
+		Trades:        trades.recent(20),
+		Notifications: notifyOutbox.recent(20),
+		Competitors:   competitors.current().Competitors,
+		Transactions:  txWatch.all(),
+		Refresh:       dashboardRefresh,
+	}
+	var err error
+	d.Balance, d.BalanceTime, err = cachedBalance()
+	if err != nil {
+		d.BalanceError = err.Error()
+	}
+	return d
+}
+
+func since(t time.Time) string {
+	return time.Since(t).Truncate(time.Second).String()
+}
+
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return since(t) + " ago"
+}
+
+var dashboardTmpl = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"ago":   ago,
+	"since": since,
+	"ts":    func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+}).Parse(dashboardHTML))
+
+func serveDashboard(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+
+	d := currentStatus()
+	d.drawCharts()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTmpl.Execute(w, d); err != nil {
+		log.Printf("Error rendering dashboard: %v", err)
+	}
+}
+
+func serveStatus(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, 200, currentStatus())
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>gembot</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 0.3em 0.8em; border-bottom: 1px solid #ddd; text-align: left; }
+th { background: #f4f4f4; }
+.num { text-align: right; font-family: monospace; }
+.mine { background: #e6f6e6; }
+.disabled { color: #999; }
+.err { color: #b00; }
+.small { font-size: 0.85em; color: #666; }
+</style>
+</head>
+<body>
+<h1>gembot</h1>
+<p>Balance:
+{{if .BalanceError}}<span class="err">{{.BalanceError}}</span>{{else}}{{.Balance}} <span class="small">(checked {{ago .BalanceTime}})</span>{{end}}
+<span class="small">as of {{ts .Time}}</span></p>
+
+<h2>Sites</h2>
+<table>
+<tr><th>Site</th><th>Value</th><th>Threshold</th><th>Owner</th><th>Locked</th>
+<th>Polling</th><th>Last check</th><th>Last error</th><th>History</th></tr>
+{{range .Sites}}
+<tr class="{{if .IsMine}}mine{{end}} {{if .Disabled}}disabled{{end}}">
+<td><a href="{{.URL}}">{{.Name}}</a></td>
+<td class="num">{{.Value}}</td>
+<td class="num">{{.Threshold}}</td>
+<td>{{if .IsMine}}me{{else if .OwnerLink}}<a href="{{.OwnerLink}}">{{.Owner}}</a>{{else}}{{.Owner}}{{end}}
+{{if not .OwnedSince.IsZero}}<span class="small">for {{since .OwnedSince}}
+(<a href="/owners/{{.Slug}}.json">history</a>)</span>{{end}}</td>
+<td>{{if .Locked}}locked{{end}}</td>
+<td>{{if .Disabled}}disabled{{else}}{{.State}}{{end}}
+{{if .Contested}}<span class="small err">contested by {{.Contested}}</span>{{end}}</td>
+<td>{{ago .LastCheck}}</td>
+<td class="err">{{if .LastError}}{{.LastError}} <span class="small">({{ago .ErrorTime}})</span>{{end}}</td>
+<td><a href="/history/{{.Slug}}.json">{{index $.Charts .Name}}</a></td>
+</tr>
+{{end}}
+</table>
+
+<h2>Transactions</h2>
+<table>
+<tr><th>Transaction</th><th>Sites</th><th>Confirmations</th><th>Watching since</th>
+<th>Last poll</th><th>Status</th></tr>
+{{range .Transactions}}
+<tr class="{{if .Ours}}mine{{end}}">
+<td class="small">{{.TXID}}</td>
+<td>{{range $i, $s := .Sites}}{{if $i}}, {{end}}{{$s}}{{end}}</td>
+<td class="num">{{if .Unknown}}?{{else}}{{.Confirmations}}{{end}}/{{.Depth}}</td>
+<td>{{ago .Added}}</td>
+<td>{{ago .LastPoll}} <span class="small">({{.Polls}} polls)</span></td>
+<td>{{if .Outcome}}{{.Outcome}}{{else if .Unknown}}<span class="err">{{.Error}}</span>{{else if .Stuck}}<span class="err">stuck</span>{{else}}pending{{end}}</td>
+</tr>
+{{else}}
+<tr><td colspan="6">No transactions being watched.</td></tr>
+{{end}}
+</table>
+
+<h2>Competitors</h2>
+<table>
+<tr><th>Owner</th><th>Wins</th><th>Fast wins</th><th>Median reaction</th>
+<th>Last win</th><th>Sites</th></tr>
+{{range .Competitors}}
+<tr class="{{if .Bot}}err{{end}}">
+<td>{{if .Link}}<a href="{{.Link}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}
+{{if .Bot}}<span class="small">(likely bot)</span>{{end}}</td>
+<td class="num">{{.Wins}}</td>
+<td class="num">{{.Fast}}/{{.Timed}}</td>
+<td class="num">{{if .Timed}}{{.Median}}{{end}}</td>
+<td>{{ago .LastWin}}</td>
+<td class="small">{{range $i, $s := .Sites}}{{if $i}}, {{end}}{{$s}}{{end}}</td>
+</tr>
+{{else}}
+<tr><td colspan="6">No competitors seen yet.</td></tr>
+{{end}}
+</table>
+
+<h2>Recent trades</h2>
+<table>
+<tr><th>Time</th><th>Site</th><th>Kind</th><th>Amount</th><th>Fee</th><th>Transaction</th><th>Status</th></tr>
+{{range .Trades}}
+<tr><td>{{ts .Time}}</td><td>{{.Site}}</td><td>{{.Kind}}</td>
+<td class="num">{{.Amount}}</td><td class="num">{{if .Fee}}{{.Fee}}{{end}}</td><td class="small">{{.TXID}}</td>
+<td>{{if .ConfirmedAt}}confirmed {{ts .ConfirmedAt}}{{else if .Status}}<span class="err">{{.Status}}</span>{{else if .TXID}}pending{{end}}</td></tr>
+{{else}}
+<tr><td colspan="7">No trades yet.</td></tr>
+{{end}}
+</table>
+
+<h2>Notifications</h2>
+<table>
+<tr><th>Time</th><th>Event</th><th>Message</th><th>Deliveries</th></tr>
+{{range .Notifications}}
+<tr><td>{{ts .Time}}</td><td>{{.Note.Event}}</td><td>{{.Note.Msg}}</td>
+<td>{{range $name, $d := .Deliveries}}{{$name}}: {{$d.Status}}{{if $d.Error}}
+<span class="err">({{$d.Error}})</span>{{end}}<br>{{end}}</td></tr>
+{{else}}
+<tr><td colspan="4">No notifications yet.</td></tr>
+{{end}}
+</table>
+</body>
+</html>
+`
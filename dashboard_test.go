@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardTemplate(t *testing.T) {
+	d := dashboardData{
+		Time:    time.Now(),
+		Balance: 123000000,
+		Sites: []siteStatus{
+			{Name: "bears", URL: "http://example.com/", Value: 73790000,
+				IsMine: true, State: "owned", LastCheck: time.Now()},
+			{Name: "<goldbar>", URL: "http://example.org/", Disabled: true,
+				LastError: "boom", ErrorTime: time.Now()},
+		},
+		Trades: []trade{{Site: "bears", Kind: tradeBuy, Amount: 73790000, TXID: "abc"}},
+		Notifications: []historyEntry{{
+			Note:       notification{Event: "Purchased", Msg: "Bought bears"},
+			Deliveries: map[string]*delivery{"phone": {Status: statusRetrying, Error: "timeout"}},
+		}},
+		Refresh: dashboardRefresh,
+	}
+
+	buf := &bytes.Buffer{}
+	if err := dashboardTmpl.Execute(buf, d); err != nil {
+		t.Fatalf("Error rendering: %v", err)
+	}
+
+	for _, exp := range []string{
+		"bears", "&lt;goldbar&gt;", "boom", "abc",
+		"phone: retrying", "timeout", `content="30"`,
+	} {
+		if !strings.Contains(buf.String(), exp) {
+			t.Errorf("Expected dashboard to contain %q", exp)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	evObservation  = "observation"
+	evState        = "state"
+	evBuyAttempt   = "buy_attempt"
+	evBuyResult    = "buy_result"
+	evBuyBlocked   = "buy_blocked"
+	evPurchase     = "purchase"
+	evSale         = "sale"
+	evNotification = "notification"
+)
+
+type event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Site string      `json:"site,omitempty"`
+	Data interface{} `json:"data"`
+}
+
+// The eventHub fans bot activity out to anyone following along.
+// Slow subscribers miss events rather than slowing down the bot.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan event]bool
+}
+
+var events = &eventHub{subs: map[chan event]bool{}}
+
+func (h *eventHub) subscribe() chan event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan event, 100)
+	h.subs[ch] = true
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subs, ch)
+}
+
+func (h *eventHub) publish(typ, site string, data interface{}) {
+	ev := event{Type: typ, Time: time.Now(), Site: site, Data: data}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func streamEvents(w http.ResponseWriter, req *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", 500)
+		return
+	}
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	f.Flush()
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case ev := <-ch:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("Error encoding event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprintf(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-req.Context().Done():
+			return
+		}
+		f.Flush()
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventStream(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(streamEvents))
+	defer s.Close()
+
+	res, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %v", ct)
+	}
+
+	for i := 0; ; i++ {
+		events.mu.Lock()
+		n := len(events.subs)
+		events.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if i > 100 {
+			t.Fatalf("Subscriber never showed up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	events.publish(evPurchase, "bears", trade{Site: "bears", TXID: "abc"})
+
+	r := bufio.NewReader(res.Body)
+	line, err := r.ReadString('\n')
+	if err != nil || line != "event: purchase\n" {
+		t.Fatalf("Expected purchase event, got %q/%v", line, err)
+	}
+	line, err = r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "data: ") ||
+		!strings.Contains(line, `"site":"bears"`) || !strings.Contains(line, `"txid":"abc"`) {
+		t.Fatalf("Expected purchase data, got %q/%v", line, err)
+	}
+}
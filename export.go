@@ -2,83 +2,388 @@ package main
 
 import (
 	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"sort"
+	"net/url"
 	"strconv"
-	"strings"
 	"time"
+
+	"github.com/dustin/go.bitcoin"
 )
 
-type doubleslice [][]string
+// walletTx is a wallet transaction as seen by the exports.
+type walletTx struct {
+	Time          time.Time
+	Account       string
+	Dir           string
+	Comment       string
+	Confirmations int
+	Amount        bitcoin.Amount
+	Fee           bitcoin.Amount
+	TXID          string
+	Site          string
+}
 
-func (p doubleslice) Len() int           { return len(p) }
-func (p doubleslice) Less(i, j int) bool { return p[i][0] < p[j][0] }
-func (p doubleslice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func btc(a bitcoin.Amount) float64 {
+	return float64(a) / 1e8
+}
 
-// Sort is a convenience method.
-func (p doubleslice) Sort() { sort.Sort(p) }
+func (t walletTx) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Time          string  `json:"time"`
+		Account       string  `json:"account"`
+		Dir           string  `json:"dir"`
+		Comment       string  `json:"comment"`
+		Confirmations int     `json:"confirmations"`
+		Satoshis      int64   `json:"amount_satoshis"`
+		BTC           float64 `json:"amount_btc"`
+		FeeSatoshis   int64   `json:"fee_satoshis"`
+		FeeBTC        float64 `json:"fee_btc"`
+		TXID          string  `json:"txid"`
+		Site          string  `json:"site,omitempty"`
+	}{
+		t.Time.Format(time.RFC3339), t.Account, t.Dir, t.Comment,
+		t.Confirmations, int64(t.Amount), btc(t.Amount),
+		int64(t.Fee), btc(t.Fee), t.TXID, t.Site,
+	})
+}
 
-func exportTransactions(w http.ResponseWriter, req *http.Request) {
-	q := req.FormValue("q")
+type txlist []walletTx
+
+// txFilter holds the request parameters common to all exports.
+type txFilter struct {
+	q       query
+	account string
+	dir     string
+	minconf int
+	from    time.Time // inclusive
+	after   time.Time
+	before  time.Time
+	limit   int
+	offset  int
+}
+
+func parseTxFilter(req *http.Request) (txFilter, error) {
+	if err := req.ParseForm(); err != nil {
+		return txFilter{}, err
+	}
+	return parseTxValues(req.Form)
+}
+
+// parseTxValues is parseTxFilter for parameters from anywhere, such
+// as the command line.
+func parseTxValues(v url.Values) (txFilter, error) {
+	f := txFilter{
+		account: v.Get("account"),
+		dir:     v.Get("dir"),
+	}
+
+	var err error
+	f.q, err = parseQuery(v.Get("q"))
+	if err != nil {
+		return f, fmt.Errorf("invalid q: %v", err)
+	}
 
-	after, _ := parseTime(req.FormValue("after"))
+	switch f.dir {
+	case "", "in", "out":
+	default:
+		return f, fmt.Errorf("invalid dir %q", f.dir)
+	}
 
-	accts, err := bc.ListAccounts()
+	loc, err := requestLocation(v)
+	if err != nil {
+		return f, err
+	}
+	now := time.Now()
+
+	if r := v.Get("range"); r != "" {
+		f.from, f.before, err = parseTimeRange(r, loc, now)
+		if err != nil {
+			return f, fmt.Errorf("invalid range %q", r)
+		}
+	}
+
+	times := []struct {
+		name string
+		dest *time.Time
+	}{
+		{"after", &f.after},
+		{"before", &f.before},
+	}
+	for _, t := range times {
+		s := v.Get(t.name)
+		if s == "" {
+			continue
+		}
+		*t.dest, err = parseTimeIn(s, loc, now)
+		if err != nil {
+			return f, fmt.Errorf("invalid %v %q", t.name, s)
+		}
+	}
+
+	ints := []struct {
+		name string
+		dest *int
+	}{
+		{"minconf", &f.minconf},
+		{"limit", &f.limit},
+		{"offset", &f.offset},
+	}
+	for _, i := range ints {
+		s := v.Get(i.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("invalid %v %q", i.name, s)
+		}
+		*i.dest = n
+	}
+
+	return f, nil
+}
+
+func (f txFilter) matches(t walletTx) bool {
+	if !f.q.matches(t) {
+		return false
+	}
+	if f.dir != "" && f.dir != t.Dir {
+		return false
+	}
+	if t.Confirmations < f.minconf {
+		return false
+	}
+	if t.Time.Before(f.from) {
+		return false
+	}
+	return t.Time.After(f.after)
+}
+
+// inRange reports whether a time falls within the filter's from,
+// after and before bounds.
+func (f txFilter) inRange(t time.Time) bool {
+	if t.Before(f.from) || !t.After(f.after) {
+		return false
+	}
+	return f.before.IsZero() || t.Before(f.before)
+}
+
+// requestLocation finds the time zone named by the tz parameter,
+// defaulting to UTC.
+func requestLocation(v url.Values) (*time.Location, error) {
+	tz := v.Get("tz")
+	if tz == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tz %q", tz)
+	}
+	return loc, nil
+}
+
+// siteForTransaction finds the configured site a wallet transaction
+// belongs to, if any.  Our own record of purchases is the most
+// reliable, then payments to a site's receive address, and finally
+// the account and comment a site sends with.
+func siteForTransaction(acct string, t bitcoin.Transaction) string {
+	addr := ""
+	if t.Amount > 0 {
+		addr = t.Address
+	}
+	if s, ok := trades.siteFor(t.TXID, addr); ok {
+		return s
+	}
+	for i := range conf.Sites {
+		s := &conf.Sites[i]
+		if t.Amount > 0 && s.RecvAddress != "" && s.RecvAddress == t.Address {
+			return s.name()
+		}
+	}
+	if t.Amount > 0 {
+		return ""
+	}
+	for i := range conf.Sites {
+		s := &conf.Sites[i]
+		if s.FromAcct != "" && s.FromAcct == acct {
+			return s.name()
+		}
+	}
+	for i := range conf.Sites {
+		s := &conf.Sites[i]
+		if s.Comment != "" && s.Comment == t.Comment {
+			return s.name()
+		}
+	}
+	return ""
+}
+
+// openStream starts a transaction stream for an export request,
+// reporting any problem to the client.
+func openStream(w http.ResponseWriter, req *http.Request) (*txStream, bool) {
+	f, err := parseTxFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+	s, err := newTxStream(f)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
-		return
+		return nil, false
 	}
+	return s, true
+}
 
-	w.Header().Set("Content-Type", "text/csv")
-	w.WriteHeader(200)
+// each calls fn for every remaining transaction in the stream.
+func (s *txStream) each(fn func(walletTx) error) error {
+	for {
+		t, ok, err := s.next()
+		if err != nil || !ok {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+}
 
+func writeCSV(w io.Writer, s *txStream) error {
 	e := csv.NewWriter(w)
 
 	e.Write([]string{"ts", "acct", "dir", "comment",
-		"confirmations", "amount", "fee", "txn"})
+		"confirmations", "amount", "fee", "txn", "site"})
 
-	var tlist doubleslice
+	err := s.each(func(t walletTx) error {
+		return e.Write([]string{
+			t.Time.Format(time.RFC3339),
+			t.Account,
+			t.Dir,
+			t.Comment,
+			strconv.Itoa(t.Confirmations),
+			t.Amount.String(),
+			t.Fee.String(),
+			t.TXID,
+			t.Site,
+		})
+	})
 
-	for acct := range accts {
-		txns, err := bc.ListTransactions(acct, 1000, 0)
+	e.Flush()
+	if err == nil {
+		err = e.Error()
+	}
+	return err
+}
+
+func writeJSONArray(w io.Writer, s *txStream) error {
+	sep := "["
+	err := s.each(func(t walletTx) error {
+		data, err := json.Marshal(t)
 		if err != nil {
-			http.Error(w, err.Error(), 500)
-			return
+			return err
 		}
-
-		for _, t := range txns {
-			if !(strings.Contains(acct, q) || strings.Contains(t.Comment, q)) {
-				continue
-			}
-			if !t.TransactionTime().After(after) {
-				continue
-			}
-			dir := "out"
-			if t.Amount > 0 {
-				dir = "in"
-			}
-			tlist = append(tlist, []string{
-				t.TransactionTime().Format(time.RFC3339),
-				acct,
-				dir,
-				t.Comment,
-				strconv.Itoa(t.Confirmations),
-				t.Amount.String(),
-				t.Fee.String(),
-				t.TXID,
-			})
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
 		}
+		sep = ","
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if sep == "[" {
+		io.WriteString(w, sep)
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+func writeNDJSON(w io.Writer, s *txStream) error {
+	e := json.NewEncoder(w)
+	return s.each(func(t walletTx) error {
+		return e.Encode(t)
+	})
+}
+
+// Once the response has started there's no way to report an error
+// to the client, so they're only logged.
+func logExportError(err error) {
+	if err != nil {
+		log.Printf("Error writing export: %v", err)
+	}
+}
+
+func exportTransactions(w http.ResponseWriter, req *http.Request) {
+	s, ok := openStream(w, req)
+	if !ok {
+		return
 	}
 
-	tlist.Sort()
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(200)
+	logExportError(writeCSV(w, s))
+}
 
-	e.WriteAll([][]string(tlist))
+func exportJSON(w http.ResponseWriter, req *http.Request) {
+	s, ok := openStream(w, req)
+	if !ok {
+		return
+	}
 
-	e.Flush()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	logExportError(writeJSONArray(w, s))
+}
+
+func exportNDJSON(w http.ResponseWriter, req *http.Request) {
+	s, ok := openStream(w, req)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(200)
+	logExportError(writeNDJSON(w, s))
 }
 
 func startHTTPServer(addr string) {
-	http.HandleFunc("/export.csv", exportTransactions)
+	routes := []struct {
+		path string
+		role int
+		h    http.HandlerFunc
+	}{
+		{"/", roleRead, serveDashboard},
+		{"/status.json", roleRead, serveStatus},
+		{"/events", roleRead, streamEvents},
+		{"/metrics", roleRead, serveMetrics},
+		{"/export.csv", roleRead, exportTransactions},
+		{"/export.json", roleRead, exportJSON},
+		{"/export.ndjson", roleRead, exportNDJSON},
+		{"/export.ledger", roleRead, exportLedger},
+		{"/export.beancount", roleRead, exportBeancount},
+		{"/report/gains.csv", roleRead, reportGains},
+		{"/history/", roleRead, serveHistory},
+		{"/owners/", roleRead, serveOwners},
+		{"/notifications", roleRead, listNotifications},
+		{"/notifications/test", roleAdmin, testNotification},
+		{"/healthz", rolePublic, serveHealth},
+		{"/readyz", rolePublic, serveReady},
+	}
+
+	for _, r := range routes {
+		http.HandleFunc(r.path, conf.HTTP.authorize(r.role, r.h))
+	}
+
+	if len(conf.HTTP.Users) == 0 {
+		log.Printf("No HTTP users configured, serving %v without authentication", addr)
+	}
+
+	if conf.HTTP.Cert != "" {
+		log.Fatal(http.ListenAndServeTLS(addr, conf.HTTP.Cert, conf.HTTP.Key, nil))
+	}
 	log.Fatal(http.ListenAndServe(addr, nil))
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+func TestWalletTxJSON(t *testing.T) {
+	tx := walletTx{
+		Time:          time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC),
+		Account:       "gems",
+		Dir:           "out",
+		Confirmations: 3,
+		Amount:        -182000000,
+		Fee:           -50000,
+		TXID:          "abc",
+		Site:          "bears",
+	}
+
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatalf("Error marshaling: %v", err)
+	}
+
+	got := map[string]interface{}{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Error unmarshaling %s: %v", data, err)
+	}
+
+	exp := map[string]interface{}{
+		"time":            "2013-05-01T12:00:00Z",
+		"confirmations":   3.0,
+		"amount_satoshis": -182000000.0,
+		"amount_btc":      -1.82,
+		"fee_satoshis":    -50000.0,
+		"fee_btc":         -0.0005,
+		"site":            "bears",
+	}
+	for k, v := range exp {
+		if got[k] != v {
+			t.Errorf("Expected %v=%v, got %v", k, v, got[k])
+		}
+	}
+}
+
+func TestSiteForTransaction(t *testing.T) {
+	oldSites, oldTrades := conf.Sites, trades
+	defer func() { conf.Sites, trades = oldSites, oldTrades }()
+
+	conf.Sites = []site{
+		{Name: "bears", RecvAddress: "1Bears", FromAcct: "bearacct"},
+		{Name: "goldbar", RecvAddress: "1Gold", Comment: "goldbar"},
+	}
+	trades = &tradeLog{Trades: []trade{
+		{Site: "kitty", Kind: tradeBuy, TXID: "kittytx", Address: "1Kitty"},
+	}}
+
+	tests := []struct {
+		acct string
+		tx   bitcoin.Transaction
+		exp  string
+	}{
+		{"", bitcoin.Transaction{TXID: "kittytx", Amount: -1}, "kitty"},
+		{"", bitcoin.Transaction{Address: "1Kitty", Amount: 1}, "kitty"},
+		{"", bitcoin.Transaction{Address: "1Bears", Amount: 1}, "bears"},
+		{"bearacct", bitcoin.Transaction{Amount: -1}, "bears"},
+		{"", bitcoin.Transaction{Comment: "goldbar", Amount: -1}, "goldbar"},
+		{"bearacct", bitcoin.Transaction{Address: "1Other", Amount: 1}, ""},
+		{"", bitcoin.Transaction{Amount: -1}, ""},
+	}
+
+	for _, test := range tests {
+		if got := siteForTransaction(test.acct, test.tx); got != test.exp {
+			t.Errorf("Expected %q for %v/%+v, got %q", test.exp, test.acct, test.tx, got)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+// A purchase is normally one input and two outputs, which is about
+// this many bytes.  It's only used to estimate fees against the cap.
+const estimatedTxSize = 250
+
+// bitcoind's default confirmation target, which is what it picks a
+// rate for when the wallet has no paytxfee set.
+const walletTargetBlocks = 6
+
+var feeTooHigh = errors.New("fee exceeds cap")
+
+// feePolicy decides the fee rate (per kB) a site's purchases pay.
+// Rate is a fixed rate, TargetBlocks asks bitcoind for a rate that
+// should confirm within that many blocks, and MaxPercent skips
+// purchases whose fee would be more than that percent of the price.
+// With neither Rate nor TargetBlocks, the wallet's own rate is used
+// and only checked against MaxPercent.  A wallet without a paytxfee
+// lets bitcoind pick, so the cap is checked against its estimate.
+type feePolicy struct {
+	Rate         bitcoin.Amount `json:"rate"`
+	TargetBlocks int            `json:"target_blocks"`
+	MaxPercent   float64        `json:"max_percent"`
+}
+
+func (p *feePolicy) validate() error {
+	switch {
+	case p.Rate < 0:
+		return errors.New("negative fee rate")
+	case p.Rate > 0 && p.TargetBlocks > 0:
+		return errors.New("rate and target_blocks are exclusive")
+	case p.TargetBlocks < 0:
+		return errors.New("negative target_blocks")
+	case p.MaxPercent < 0:
+		return errors.New("negative max_percent")
+	}
+	return nil
+}
+
+// estimateFee asks bitcoind for a fee rate likely to confirm within
+// the given number of blocks.
+var estimateFee = func(blocks int) (bitcoin.Amount, error) {
+	var rate float64
+	if err := callBitcoind("estimatefee", &rate, blocks); err != nil {
+		return 0, err
+	}
+	if rate <= 0 {
+		return 0, fmt.Errorf("no fee estimate for %v blocks", blocks)
+	}
+	return toAmount(rate), nil
+}
+
+func toAmount(b float64) bitcoin.Amount {
+	return bitcoin.Amount(math.Floor(b*1e8 + 0.5))
+}
+
+// A feeQuote is what a policy worked out to for one purchase.  A zero
+// Rate leaves the wallet's own fee alone.
+type feeQuote struct {
+	Rate     bitcoin.Amount
+	Estimate bitcoin.Amount
+}
+
+func (p *feePolicy) quote(amt bitcoin.Amount) (feeQuote, error) {
+	var q feeQuote
+	if p == nil {
+		return q, nil
+	}
+
+	q.Rate = p.Rate
+	if p.TargetBlocks > 0 {
+		var err error
+		q.Rate, err = estimateFee(p.TargetBlocks)
+		if err != nil {
+			return q, err
+		}
+	}
+
+	rate := q.Rate
+	if rate == 0 && p.MaxPercent > 0 {
+		var err error
+		rate, err = walletTxFee()
+		if err == nil && rate == 0 {
+			rate, err = estimateFee(walletTargetBlocks)
+		}
+		if err != nil {
+			return q, err
+		}
+	}
+	q.Estimate = rate * estimatedTxSize / 1000
+
+	if p.MaxPercent > 0 && float64(q.Estimate) > float64(amt)*p.MaxPercent/100 {
+		log.Printf("Fee of %v on %v would be over %v%%",
+			q.Estimate, amt, p.MaxPercent)
+		return q, feeTooHigh
+	}
+	return q, nil
+}
+
+// The fee rate is wallet-wide, so everything that sends holds this
+// from setting the rate until it's put back.
+var feeMu sync.Mutex
+
+var setTxFee = func(rate bitcoin.Amount) error {
+	return callBitcoind("settxfee", nil, btc(rate))
+}
+
+var walletTxFee = func() (bitcoin.Amount, error) {
+	var info struct {
+		PayTxFee float64 `json:"paytxfee"`
+	}
+	if err := callBitcoind("getinfo", &info); err != nil {
+		return 0, err
+	}
+	return toAmount(info.PayTxFee), nil
+}
+
+// apply sets the wallet up to send with this quote, returning a
+// function to call once the payment's been sent.
+func (q feeQuote) apply() (func(), error) {
+	feeMu.Lock()
+	if q.Rate == 0 {
+		return feeMu.Unlock, nil
+	}
+
+	prev, err := walletTxFee()
+	if err != nil {
+		feeMu.Unlock()
+		return nil, err
+	}
+	if err := setTxFee(q.Rate); err != nil {
+		feeMu.Unlock()
+		return nil, err
+	}
+	return func() {
+		defer feeMu.Unlock()
+		if err := setTxFee(prev); err != nil {
+			log.Printf("Error restoring fee rate to %v: %v", prev, err)
+		}
+	}, nil
+}
+
+// paidFee finds the fee our wallet actually paid on a transaction.
+var paidFee = func(txid string) (bitcoin.Amount, error) {
+	var tx struct {
+		Fee float64 `json:"fee"`
+	}
+	if err := callBitcoind("gettransaction", &tx, txid); err != nil {
+		return 0, err
+	}
+	return toAmount(math.Abs(tx.Fee)), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+func TestFeePolicyValidate(t *testing.T) {
+	tests := []struct {
+		p  feePolicy
+		ok bool
+	}{
+		{feePolicy{}, true},
+		{feePolicy{Rate: 10000}, true},
+		{feePolicy{TargetBlocks: 2, MaxPercent: 1}, true},
+		{feePolicy{Rate: 10000, TargetBlocks: 2}, false},
+		{feePolicy{Rate: -1}, false},
+		{feePolicy{MaxPercent: 1}, true},
+		{feePolicy{MaxPercent: -1}, false},
+	}
+
+	for _, test := range tests {
+		err := test.p.validate()
+		if (err == nil) != test.ok {
+			t.Errorf("Expected %+v ok=%v, got %v", test.p, test.ok, err)
+		}
+	}
+}
+
+func TestFeeQuote(t *testing.T) {
+	prev, prevWallet := estimateFee, walletTxFee
+	defer func() { estimateFee, walletTxFee = prev, prevWallet }()
+	estimateFee = func(blocks int) (bitcoin.Amount, error) {
+		return bitcoin.Amount(blocks * 10000), nil
+	}
+	walletTxFee = func() (bitcoin.Amount, error) { return 80000, nil }
+
+	tests := []struct {
+		p    *feePolicy
+		amt  bitcoin.Amount
+		rate bitcoin.Amount
+		err  error
+	}{
+		{nil, 1e8, 0, nil},
+		{&feePolicy{Rate: 40000}, 1e8, 40000, nil},
+		{&feePolicy{TargetBlocks: 3}, 1e8, 30000, nil},
+		// 40000/kB on 250 bytes is 10000, which is 1% of 1000000.
+		{&feePolicy{Rate: 40000, MaxPercent: 1}, 1000000, 40000, nil},
+		{&feePolicy{Rate: 40000, MaxPercent: 1}, 999999, 40000, feeTooHigh},
+		// The wallet's own 80000/kB is capped without being set.
+		{&feePolicy{MaxPercent: 1}, 2000000, 0, nil},
+		{&feePolicy{MaxPercent: 1}, 1999999, 0, feeTooHigh},
+	}
+
+	for _, test := range tests {
+		q, err := test.p.quote(test.amt)
+		if q.Rate != test.rate || err != test.err {
+			t.Errorf("On %+v of %v, expected %v/%v, got %v/%v",
+				test.p, test.amt, test.rate, test.err, q.Rate, err)
+		}
+	}
+}
+
+func TestFeeQuoteDefaultWallet(t *testing.T) {
+	prev, prevWallet := estimateFee, walletTxFee
+	defer func() { estimateFee, walletTxFee = prev, prevWallet }()
+	walletTxFee = func() (bitcoin.Amount, error) { return 0, nil }
+
+	var asked []int
+	estimateFee = func(blocks int) (bitcoin.Amount, error) {
+		asked = append(asked, blocks)
+		return 80000, nil
+	}
+	p := &feePolicy{MaxPercent: 1}
+	if q, err := p.quote(1999999); q.Rate != 0 || err != feeTooHigh {
+		t.Errorf("Expected an unset wallet fee to be capped, got %v/%v", q, err)
+	}
+	if len(asked) != 1 || asked[0] != walletTargetBlocks {
+		t.Errorf("Expected an estimate for %v blocks, got %v",
+			walletTargetBlocks, asked)
+	}
+
+	estimateFee = func(blocks int) (bitcoin.Amount, error) {
+		return 0, errors.New("no estimate")
+	}
+	if _, err := p.quote(1e8); err == nil {
+		t.Errorf("Expected no estimate to refuse the purchase")
+	}
+}
+
+func TestFeeApply(t *testing.T) {
+	prevSet, prevGet := setTxFee, walletTxFee
+	defer func() { setTxFee, walletTxFee = prevSet, prevGet }()
+
+	var set []bitcoin.Amount
+	setTxFee = func(rate bitcoin.Amount) error {
+		set = append(set, rate)
+		return nil
+	}
+	walletTxFee = func() (bitcoin.Amount, error) { return 1000, nil }
+
+	release, err := feeQuote{}.apply()
+	if err != nil {
+		t.Fatalf("Error applying default fee: %v", err)
+	}
+	release()
+	if len(set) != 0 {
+		t.Errorf("Expected default fees to leave the wallet alone, got %v", set)
+	}
+
+	release, err = feeQuote{Rate: 50000}.apply()
+	if err != nil {
+		t.Fatalf("Error applying fee: %v", err)
+	}
+	release()
+	if len(set) != 2 || set[0] != 50000 || set[1] != 1000 {
+		t.Errorf("Expected the fee to be set and restored, got %v", set)
+	}
+}
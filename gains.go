@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+const longTerm = 365 * 24 * time.Hour
+
+// A lot is a purchase that hasn't been sold yet.
+type lot struct {
+	Time time.Time
+	Cost bitcoin.Amount // including fees
+	Fee  bitcoin.Amount
+	TXID string
+}
+
+// A realized gain pairs a sale with the purchase it closes out.
+// Bought is zero for a sale with no matching purchase.
+type gain struct {
+	Site      string
+	Bought    time.Time
+	Sold      time.Time
+	Proceeds  bitcoin.Amount
+	CostBasis bitcoin.Amount
+	Fee       bitcoin.Amount
+	BuyTXID   string
+	SellTXID  string
+}
+
+func (g gain) Gain() bitcoin.Amount {
+	return g.Proceeds - g.CostBasis
+}
+
+func (g gain) Holding() time.Duration {
+	if g.Bought.IsZero() {
+		return 0
+	}
+	return g.Sold.Sub(g.Bought)
+}
+
+// realizedGains pairs each sale with the oldest unsold purchase from
+// the same site.  Transactions not attributed to a site are ignored.
+func realizedGains(tlist txlist) []gain {
+	lots := map[string][]lot{}
+	var rv []gain
+
+	for _, t := range tlist {
+		if t.Site == "" {
+			continue
+		}
+		fee := t.Fee
+		if fee < 0 {
+			fee = -fee
+		}
+		if t.Amount < 0 {
+			lots[t.Site] = append(lots[t.Site], lot{
+				Time: t.Time,
+				Cost: -t.Amount + fee,
+				Fee:  fee,
+				TXID: t.TXID,
+			})
+			continue
+		}
+
+		g := gain{
+			Site:     t.Site,
+			Sold:     t.Time,
+			Proceeds: t.Amount - fee,
+			Fee:      fee,
+			SellTXID: t.TXID,
+		}
+		if q := lots[t.Site]; len(q) > 0 {
+			g.Bought = q[0].Time
+			g.CostBasis = q[0].Cost
+			g.Fee += q[0].Fee
+			g.BuyTXID = q[0].TXID
+			lots[t.Site] = q[1:]
+		}
+		rv = append(rv, g)
+	}
+
+	return rv
+}
+
+type ratePoint struct {
+	Time time.Time
+	Rate float64
+}
+
+// rateTable holds fiat prices of one bitcoin over time.
+type rateTable []ratePoint
+
+func (r rateTable) Len() int           { return len(r) }
+func (r rateTable) Less(i, j int) bool { return r[i].Time.Before(r[j].Time) }
+func (r rateTable) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// loadRates reads a CSV file of date,rate pairs.
+func loadRates(fn string) (rateTable, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.Comment = '#'
+
+	var rv rateTable
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		t, err := parseTime(rec[0])
+		if err != nil {
+			// Allow a header line.
+			if len(rv) == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("Error parsing date %q: %v", rec[0], err)
+		}
+		rate, err := strconv.ParseFloat(rec[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing rate %q: %v", rec[1], err)
+		}
+		rv = append(rv, ratePoint{t, rate})
+	}
+
+	sort.Sort(rv)
+	return rv, nil
+}
+
+// at finds the most recent rate at or before the given time.
+func (r rateTable) at(t time.Time) (float64, bool) {
+	i := sort.Search(len(r), func(i int) bool { return r[i].Time.After(t) })
+	if i == 0 {
+		return 0, false
+	}
+	return r[i-1].Rate, true
+}
+
+func fiat(a bitcoin.Amount, rate float64) string {
+	return strconv.FormatFloat(btc(a)*rate, 'f', 2, 64)
+}
+
+func writeGains(w io.Writer, gains []gain, rates rateTable) error {
+	e := csv.NewWriter(w)
+
+	hdr := []string{"site", "bought", "sold", "holding_days", "term",
+		"proceeds", "cost_basis", "fees", "gain"}
+	if rates != nil {
+		hdr = append(hdr, "proceeds_fiat", "cost_basis_fiat", "gain_fiat")
+	}
+	hdr = append(hdr, "buy_txid", "sell_txid")
+	e.Write(hdr)
+
+	for _, g := range gains {
+		bought, term := "", ""
+		if !g.Bought.IsZero() {
+			bought = g.Bought.Format(time.RFC3339)
+			term = "short"
+			if g.Holding() > longTerm {
+				term = "long"
+			}
+		}
+		row := []string{
+			g.Site,
+			bought,
+			g.Sold.Format(time.RFC3339),
+			strconv.FormatFloat(g.Holding().Hours()/24, 'f', 2, 64),
+			term,
+			formatBTC(g.Proceeds),
+			formatBTC(g.CostBasis),
+			formatBTC(g.Fee),
+			formatBTC(g.Gain()),
+		}
+		if rates != nil {
+			sellRate, sok := rates.at(g.Sold)
+			buyRate, bok := rates.at(g.Bought)
+			if !(sok && (bok || g.Bought.IsZero())) {
+				row = append(row, "", "", "")
+			} else {
+				p := btc(g.Proceeds) * sellRate
+				c := btc(g.CostBasis) * buyRate
+				row = append(row, fiat(g.Proceeds, sellRate),
+					fiat(g.CostBasis, buyRate),
+					strconv.FormatFloat(p-c, 'f', 2, 64))
+			}
+		}
+		row = append(row, g.BuyTXID, g.SellTXID)
+		e.Write(row)
+	}
+
+	e.Flush()
+	return e.Error()
+}
+
+// parseGainsFilter parses the parameters of a gains report, which are
+// those of the exports less dir, since every sale is incoming.
+func parseGainsFilter(v url.Values) (txFilter, error) {
+	if v.Get("dir") != "" {
+		return txFilter{}, errors.New("dir doesn't apply to gains")
+	}
+	return parseTxValues(v)
+}
+
+// gainsReport computes gains over the entire wallet history (so
+// every sale can find its purchase), keeping sales within the
+// filter's time range whose transactions match the rest of it.
+func gainsReport(f txFilter) ([]gain, error) {
+	tlist, err := walletTransactions(txFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	sales := map[string]walletTx{}
+	for _, t := range tlist {
+		if t.Amount > 0 {
+			sales[t.TXID] = t
+		}
+	}
+
+	var rv []gain
+	for _, g := range realizedGains(tlist) {
+		t := sales[g.SellTXID]
+		if f.account != "" && f.account != t.Account {
+			continue
+		}
+		if f.inRange(g.Sold) && f.matches(t) {
+			rv = append(rv, g)
+		}
+	}
+
+	if f.offset >= len(rv) {
+		return nil, nil
+	}
+	rv = rv[f.offset:]
+	if f.limit > 0 && len(rv) > f.limit {
+		rv = rv[:f.limit]
+	}
+	return rv, nil
+}
+
+func reportGains(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	f, err := parseGainsFilter(req.Form)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	gains, err := gainsReport(f)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	var rates rateTable
+	if conf.RatesFile != "" {
+		rates, err = loadRates(conf.RatesFile)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(200)
+	writeGains(w, gains, rates)
+}
+
+// printGains writes the gains report to stdout.  The filter is in the
+// same form as the query string of /report/gains.csv.
+func printGains(ratesFile, filter string) {
+	v, err := url.ParseQuery(filter)
+	if err != nil {
+		log.Fatalf("Error parsing gains filter: %v", err)
+	}
+	f, err := parseGainsFilter(v)
+	if err != nil {
+		log.Fatalf("Invalid gains filter: %v", err)
+	}
+
+	if ratesFile == "" {
+		ratesFile = conf.RatesFile
+	}
+
+	var rates rateTable
+	if ratesFile != "" {
+		rates, err = loadRates(ratesFile)
+		if err != nil {
+			log.Fatalf("Error loading rates: %v", err)
+		}
+	}
+
+	gains, err := gainsReport(f)
+	if err != nil {
+		log.Fatalf("Error computing gains: %v", err)
+	}
+
+	if err := writeGains(os.Stdout, gains, rates); err != nil {
+		log.Fatalf("Error writing gains: %v", err)
+	}
+}
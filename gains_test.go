@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+func day(d int) time.Time {
+	return time.Date(2013, 5, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestRealizedGains(t *testing.T) {
+	tlist := txlist{
+		{Time: day(1), Site: "a", Amount: -100000000, Fee: -10000, TXID: "b1"},
+		{Time: day(2), Site: "a", Amount: -150000000, Fee: -10000, TXID: "b2"},
+		{Time: day(2), Amount: -5000, TXID: "unrelated"},
+		{Time: day(3), Site: "a", Amount: 120000000, TXID: "s1"},
+		{Time: day(4), Site: "b", Amount: 50000000, TXID: "s2"},
+		{Time: day(5), Site: "a", Amount: 140000000, TXID: "s3"},
+	}
+
+	gains := realizedGains(tlist)
+	if len(gains) != 3 {
+		t.Fatalf("Expected 3 gains, got %v", gains)
+	}
+
+	g := gains[0]
+	if g.BuyTXID != "b1" || g.SellTXID != "s1" {
+		t.Errorf("Expected b1 -> s1 first, got %+v", g)
+	}
+	if g.CostBasis != 100010000 || g.Gain() != 19990000 {
+		t.Errorf("Expected basis 100010000 and gain 19990000, got %+v", g)
+	}
+	if g.Holding() != 48*time.Hour {
+		t.Errorf("Expected two days holding, got %v", g.Holding())
+	}
+
+	if gains[1].Site != "b" || !gains[1].Bought.IsZero() || gains[1].CostBasis != 0 {
+		t.Errorf("Expected unmatched sale for b, got %+v", gains[1])
+	}
+
+	if gains[2].BuyTXID != "b2" || gains[2].Gain() != -10010000 {
+		t.Errorf("Expected b2 -> s3 at a loss, got %+v", gains[2])
+	}
+}
+
+func TestGainsFiat(t *testing.T) {
+	f, err := ioutil.TempFile("", "rates")
+	if err != nil {
+		t.Fatalf("Error creating rates file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("date,rate\n2013-05-03,120\n2013-05-01,100\n")
+	f.Close()
+
+	rates, err := loadRates(f.Name())
+	if err != nil {
+		t.Fatalf("Error loading rates: %v", err)
+	}
+	if r, ok := rates.at(day(2)); !ok || r != 100 {
+		t.Errorf("Expected rate 100 on day 2, got %v", r)
+	}
+	if _, ok := rates.at(time.Date(2013, 4, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Errorf("Expected no rate before the first entry")
+	}
+
+	gains := []gain{{
+		Site:      "a",
+		Bought:    day(1),
+		Sold:      day(3),
+		Proceeds:  200000000,
+		CostBasis: 100000000,
+	}}
+
+	buf := &bytes.Buffer{}
+	if err := writeGains(buf, gains, rates); err != nil {
+		t.Fatalf("Error writing gains: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	exp := "a,2013-05-01T00:00:00Z,2013-05-03T00:00:00Z,2.00,short," +
+		"2.00000000,1.00000000,0.00000000,1.00000000,240.00,100.00,140.00,,"
+	if len(lines) != 2 || lines[1] != exp {
+		t.Errorf("Expected %v, got %v", exp, lines)
+	}
+}
+
+func TestReportGainsFilter(t *testing.T) {
+	oldSites, oldTrades := conf.Sites, trades
+	defer func() { conf.Sites, trades = oldSites, oldTrades }()
+	conf.Sites = []site{{Name: "a", RecvAddress: "1A", Comment: "a"}}
+	trades = &tradeLog{}
+
+	tx := func(d int, amt bitcoin.Amount, txid string) bitcoin.Transaction {
+		rv := bitcoin.Transaction{Time: day(d).Unix(), Amount: amt, TXID: txid}
+		if amt < 0 {
+			rv.Comment = "a"
+		} else {
+			rv.Address = "1A"
+		}
+		return rv
+	}
+	defer fakeWallet(t, map[string][]bitcoin.Transaction{
+		"": {
+			tx(1, -100000000, "b1"),
+			tx(2, -100000000, "b2"),
+			tx(3, -100000000, "b3"),
+			tx(4, 110000000, "s4"),
+			tx(6, 120000000, "s6"),
+			tx(8, 130000000, "s8"),
+		},
+	})()
+
+	tests := []struct {
+		params string
+		exp    []string
+	}{
+		{"", []string{"s4", "s6", "s8"}},
+		{"range=2013-05-03..2013-05-07", []string{"s4", "s6"}},
+		{"before=2013-05-06", []string{"s4"}},
+		{"after=2013-05-04&before=2013-05-09", []string{"s6", "s8"}},
+		{"q=amount:>1.15", []string{"s6", "s8"}},
+		{"q=-txid:s6&before=2013-05-07", []string{"s4"}},
+		{"account=other", nil},
+		{"minconf=1", nil},
+		{"limit=2", []string{"s4", "s6"}},
+		{"offset=1&limit=1", []string{"s6"}},
+		{"offset=5", nil},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		reportGains(w, httptest.NewRequest("GET", "/report/gains.csv?"+test.params, nil))
+		if w.Code != 200 {
+			t.Errorf("%q: expected 200, got %v: %v", test.params, w.Code, w.Body)
+			continue
+		}
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n")[1:] {
+			f := strings.Split(line, ",")
+			got = append(got, f[len(f)-1])
+		}
+		if strings.Join(got, " ") != strings.Join(test.exp, " ") {
+			t.Errorf("%q: expected sales %v, got %v", test.params, test.exp, got)
+		}
+	}
+
+	for _, params := range []string{"range=nonsense", "q=amount:%3Ewhat", "dir=in"} {
+		w := httptest.NewRecorder()
+		reportGains(w, httptest.NewRequest("GET", "/report/gains.csv?"+params, nil))
+		if w.Code != 400 {
+			t.Errorf("Expected %q to be rejected, got %v", params, w.Code)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"html"
 	"io"
 	"io/ioutil"
 	"log"
@@ -13,7 +14,9 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dustin/go.bitcoin"
@@ -27,6 +30,7 @@ const (
 	tooHigh
 	owned
 	aggressive
+	sprint
 )
 
 var durations = map[int]time.Duration{
@@ -34,6 +38,7 @@ var durations = map[int]time.Duration{
 	owned:      time.Minute * 3,
 	normal:     time.Second * 45,
 	aggressive: time.Second * 10,
+	sprint:     time.Second * 2,
 }
 
 type State struct {
@@ -42,6 +47,10 @@ type State struct {
 	Locked  bool
 	Value   bitcoin.Amount
 	Pending string
+
+	OwnerName    string
+	OwnerLink    string
+	OwnerAddress string
 }
 
 var costFinders = []*regexp.Regexp{
@@ -50,6 +59,8 @@ var costFinders = []*regexp.Regexp{
 	regexp.MustCompile(`re-homing fee is ([\d.]+) bitcoins?`),
 }
 
+var ownerLink = regexp.MustCompile(`<a[^>]*href="([^"]*)"[^>]*>([^<]*)</a>`)
+
 var unknownData = errors.New("I don't recognize the data")
 var insufficientFunds = errors.New("insufficient funds")
 var maybeOwned = errors.New("possibly already own this")
@@ -57,6 +68,7 @@ var maybeOwned = errors.New("possibly already own this")
 var bc *bitcoin.BitcoindClient
 
 type site struct {
+	Name        string         `json:"name"`
 	Threshold   bitcoin.Amount `json:"threshold"`
 	ReadURL     string         `json:"read"`
 	BuyURL      string         `json:"buy"`
@@ -66,6 +78,17 @@ type site struct {
 	FromAcct    string         `json:"fromacct"`
 	Comment     string         `json:"comment"`
 	Disabled    bool           `json:"disabled"`
+	Fee         *feePolicy     `json:"fee"`
+	// What to do when a likely bot keeps beating us here:
+	// "yield" or "compete".
+	OnBot string `json:"on_bot"`
+	// Get a new wallet address for each purchase instead of
+	// using RecvAddress.
+	FreshAddress bool `json:"fresh_address"`
+
+	// Accounts used for this site in plain-text accounting exports.
+	ExpenseAccount string `json:"expense_account"`
+	IncomeAccount  string `json:"income_account"`
 
 	state       int
 	latestTx    string
@@ -80,9 +103,18 @@ var conf = struct {
 
 	Sites         []site
 	Notifications []notifier
+	DedupWindow   duration `json:"dedup_window"`
+	Accounting    accounting
+	RatesFile     string `json:"rates"`
+	Snapshots     *snapshotConf
+	HTTP          httpConf       `json:"http"`
+	Health        healthConf     `json:"health"`
+	Competitors   competitorConf `json:"competitors"`
+	Purchases     purchaseConf   `json:"purchases"`
 }{}
 
 var myAddresses = map[string]bool{}
+var myAddressesMu sync.RWMutex
 
 const buyStateFile = ",buystate.json"
 
@@ -112,22 +144,33 @@ func initAmounts() map[string]bitcoin.Amount {
 	return rv
 }
 
-func persistState(st interface{}) {
-	tmpfile := buyStateFile + ".tmp"
+// writeAtomically writes a file via a temporary file so a partial
+// write never appears under the real name.
+func writeAtomically(fn string, write func(io.Writer) error) error {
+	tmpfile := fn + ".tmp"
 	f, err := os.Create(tmpfile)
 	if err != nil {
-		log.Printf("Error creating tmp file: %v", err)
-		return
+		return err
 	}
-	defer f.Close()
 
-	e := json.NewEncoder(f)
-	err = e.Encode(st)
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
-		log.Printf("Error encoding state: %v", err)
+		os.Remove(tmpfile)
+		return err
 	}
+	return os.Rename(tmpfile, fn)
+}
 
-	os.Rename(tmpfile, buyStateFile)
+func persistState(fn string, st interface{}) {
+	err := writeAtomically(fn, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(st)
+	})
+	if err != nil {
+		log.Printf("Error saving state to %v: %v", fn, err)
+	}
 }
 
 func buyMonitor() {
@@ -136,7 +179,10 @@ func buyMonitor() {
 	for {
 		select {
 		case req := <-buyReq:
+			done := timeRPC("getbalance")
 			balance, err := bc.GetBalance()
+			done()
+			recordBalance(balance, err)
 			log.Printf("Request to buy %v at %v with a balance of %v",
 				req.site, req.amt, balance)
 			switch {
@@ -151,7 +197,7 @@ func buyMonitor() {
 			}
 		case req := <-buyComplete:
 			lastBuy[req.site] = req.amt
-			persistState(lastBuy)
+			persistState(buyStateFile, lastBuy)
 			close(req.res)
 		case st := <-buyState:
 			lb, ok := lastBuy[st.Site]
@@ -160,19 +206,32 @@ func buyMonitor() {
 			}
 
 			if !st.IsMine && st.Value > lb {
-				notifyCh <- notification{
+				t := trade{
+					Site:   siteName(st.Site),
+					Kind:   tradeSell,
+					Time:   time.Now(),
+					Amount: st.Value,
+				}
+				trades.add(t)
+				events.publish(evSale, t.Site, t)
+				postNotification(notification{
 					Event: "Sold " + st.Site,
 					Msg: "Sold " + st.Site + " at " + st.Value.String() +
 						" after buying at " + lb.String(),
-				}
+					Severity: sevInfo,
+					Site:     siteName(st.Site),
+				})
 				delete(lastBuy, st.Site)
-				persistState(lastBuy)
+				persistState(buyStateFile, lastBuy)
 			}
 		}
 	}
 }
 
 func isMyAddress(a string) bool {
+	myAddressesMu.RLock()
+	defer myAddressesMu.RUnlock()
+
 	for aa := range myAddresses {
 		if strings.Contains(a, aa) {
 			return true
@@ -205,6 +264,7 @@ func parse(site string, r io.Reader, rurl string) (State, error) {
 		if worth != "" {
 			h := g.Find(loc).Html()
 			rv.IsMine = (rurl != "" && strings.Contains(h, rurl)) || isMyAddress(h)
+			rv.OwnerName, rv.OwnerLink, rv.OwnerAddress = parseOwner(h)
 			break
 		}
 	}
@@ -226,6 +286,25 @@ func parse(site string, r io.Reader, rurl string) (State, error) {
 	return rv, err
 }
 
+// parseOwner finds the current owner's name and link in the owner
+// block.  Owners who didn't give a link of their own are linked to
+// their address on a block explorer.
+func parseOwner(h string) (name, link, address string) {
+	m := ownerLink.FindStringSubmatch(h)
+	if m == nil {
+		return
+	}
+	link = html.UnescapeString(m[1])
+	name = strings.TrimSpace(html.UnescapeString(m[2]))
+	if link == "http://" {
+		link = ""
+	}
+	if x := strings.Index(link, "/address/"); x >= 0 {
+		address = strings.Trim(link[x+len("/address/"):], "/")
+	}
+	return
+}
+
 func parseAddress(s string) string {
 	if s[0] == '{' {
 		ob := struct{ Address string }{}
@@ -235,9 +314,59 @@ func parseAddress(s string) string {
 	return s
 }
 
-func (s *site) buy(amt bitcoin.Amount) (bought bool, err error) {
+// name is how the site is identified to humans, falling back to
+// its URL when no name is configured.
+func (s *site) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.ReadURL
+}
+
+// slug is the site's name made safe for file names and URL paths.
+func (s *site) slug() string {
+	return siteSlug(s.name())
+}
+
+func siteSlug(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// findSiteBySlug finds the configured site with the given slug.
+func findSiteBySlug(slug string) *site {
+	for i := range conf.Sites {
+		if conf.Sites[i].slug() == slug {
+			return &conf.Sites[i]
+		}
+	}
+	return nil
+}
+
+// siteName finds the name of the configured site with the given URL.
+func siteName(u string) string {
+	for i := range conf.Sites {
+		if conf.Sites[i].ReadURL == u {
+			return conf.Sites[i].name()
+		}
+	}
+	return u
+}
+
+func (s *site) buy(amt bitcoin.Amount, fee feeQuote) (bought bool, err error) {
+	addr, err := s.receiveAddress(time.Now())
+	if err != nil {
+		return false, err
+	}
+
 	data := url.Values{
-		"address":   {s.RecvAddress},
+		"address":   {addr},
 		"user_name": {s.MyName},
 		"user_link": {s.MyUrl},
 	}
@@ -267,7 +396,9 @@ func (s *site) buy(amt bitcoin.Amount) (bought bool, err error) {
 	}
 	ress := parseAddress(strings.TrimSpace(string(resdata)))
 
+	done := timeRPC("validateaddress")
 	x, err := bc.ValidateAddress(ress)
+	done()
 	if err != nil {
 		return false, err
 	}
@@ -277,40 +408,80 @@ func (s *site) buy(amt bitcoin.Amount) (bought bool, err error) {
 
 	log.Printf("Sending %v to %v for %v", amt, x.Address, s.ReadURL)
 
+	release, err := fee.apply()
+	if err != nil {
+		return false, err
+	}
+	if err := sweepProceeds(conf.Sites); err != nil {
+		log.Printf("Error moving sale proceeds: %v", err)
+	}
 	var txn string
 	if s.FromAcct == "" {
+		done = timeRPC("sendtoaddress")
 		txn, err = bc.SendToAddress(x.Address, amt, s.Comment, "")
 	} else {
+		done = timeRPC("sendfrom")
 		txn, err = bc.SendFrom(s.FromAcct, x.Address, amt, -1, s.Comment, "")
 	}
+	done()
+	release()
 
 	if err == nil {
 		bought = true
-		s.markPurchased(txn, amt)
+		s.markPurchased(txn, amt, fee, addr)
 	}
 
 	return
 }
 
-func (s *site) markPurchased(txn string, amt bitcoin.Amount) {
+func (s *site) markPurchased(txn string, amt bitcoin.Amount, fee feeQuote, addr string) {
 	log.Printf("Sent txn %v", txn)
 	s.latestTx = txn
+	txWatch.watchPurchase(txn, s.name(), time.Now(), conf.Purchases.depth())
+
+	t := trade{
+		Site:    s.name(),
+		Kind:    tradeBuy,
+		Time:    time.Now(),
+		Amount:  amt,
+		TXID:    txn,
+		Address: addr,
+		FeeRate: fee.Rate,
+	}
+	var err error
+	t.Fee, err = paidFee(txn)
+	if err != nil {
+		log.Printf("Error finding the fee paid on %v: %v", txn, err)
+	}
+	trades.add(t)
+	events.publish(evPurchase, t.Site, t)
 
 	buyComplete <- buyIntent{s.ReadURL, amt, make(chan error)}
 
-	notifyCh <- notification{
-		Event: "Purchased from " + s.ReadURL,
-		Msg:   "Bought from " + s.ReadURL + " at " + amt.String() + " with " + txn,
-	}
+	postNotification(notification{
+		Event:    "Purchased from " + s.ReadURL,
+		Msg:      "Bought from " + s.ReadURL + " at " + amt.String() + " with " + txn,
+		Severity: sevInfo,
+		Site:     s.name(),
+	})
 }
 
 func (s *site) checkSite() (bought bool, err error) {
-	defer func(start time.Time) {
+	mChecks.inc(s.name())
+	defer func(start time.Time, prev int) {
 		duration := time.Since(start)
 		if duration > time.Second*5 {
 			log.Printf("Took %v to check %v", duration, s.ReadURL)
 		}
-	}(time.Now())
+		mCheckLatency.observe(duration.Seconds(), s.name())
+		siteStatuses.checked(s, err)
+		if s.state != prev {
+			events.publish(evState, s.name(), map[string]string{
+				"from": stateNames[prev],
+				"to":   stateNames[s.state],
+			})
+		}
+	}(time.Now(), s.state)
 
 	s.state = normal
 
@@ -326,15 +497,28 @@ func (s *site) checkSite() (bought bool, err error) {
 
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
+		mFetchErrors.inc(s.name(), "error")
 		return false, err
 	}
 	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		mFetchErrors.inc(s.name(), strconv.Itoa(res.StatusCode))
+	}
 	st, err := parse(s.ReadURL, io.LimitReader(res.Body, minRead), s.MyUrl)
 	if err != nil {
+		mParseFailures.inc(s.name())
 		return false, err
 	}
 
 	buyState <- st
+	siteStatuses.observed(st)
+	events.publish(evObservation, s.name(), st)
+	if err := priceHistory.observe(s.name(), st, time.Now()); err != nil {
+		log.Printf("Error recording history of %v: %v", s.ReadURL, err)
+	}
+	if o, changed := owners.observe(s.name(), st, time.Now()); changed {
+		log.Printf("%v is now owned by %v", s.ReadURL, o.Name)
+	}
 
 	s.pendingTx = st.Pending
 
@@ -352,7 +536,8 @@ func (s *site) checkSite() (bought bool, err error) {
 	if st.Value <= s.Threshold {
 		if st.Locked {
 			log.Printf("Purchasing of %v is locked", s.ReadURL)
-			s.state = aggressive
+			mBuyBlocks.inc(s.name(), "locked")
+			s.state = s.eagerState()
 			return false, nil
 		}
 
@@ -362,18 +547,55 @@ func (s *site) checkSite() (bought bool, err error) {
 
 		if err != nil {
 			log.Printf("Buy manager is blocking us from buying: %v", err)
+			mBuyBlocks.inc(s.name(), blockReason(err))
+			events.publish(evBuyBlocked, s.name(), map[string]string{
+				"amount": st.Value.String(),
+				"reason": err.Error(),
+			})
 			s.state = owned
 			return false, nil
 		}
 
+		var fee feeQuote
+		fee, err = s.Fee.quote(st.Value)
+		if err == feeTooHigh {
+			mBuyBlocks.inc(s.name(), blockReason(err))
+			events.publish(evBuyBlocked, s.name(), map[string]string{
+				"amount": st.Value.String(),
+				"reason": err.Error(),
+			})
+			// Stay at the normal pace; there's no point asking
+			// about fees every few seconds.
+			return false, err
+		}
+		if err != nil {
+			return false, err
+		}
+
 		log.Printf("Hey, we'll give that a bid!")
-		bought, err = s.buy(st.Value)
+		mBuyAttempts.inc(s.name())
+		events.publish(evBuyAttempt, s.name(), map[string]string{
+			"amount": st.Value.String(),
+		})
+		bought, err = s.buy(st.Value, fee)
+		if bought {
+			mBuySuccesses.inc(s.name())
+		}
+
+		result := map[string]interface{}{
+			"amount": st.Value.String(),
+			"bought": bought,
+		}
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		events.publish(evBuyResult, s.name(), result)
 	}
 
 	if bought || st.IsMine {
 		s.state = owned
 	} else if st.Value <= s.Threshold {
-		s.state = aggressive
+		s.state = s.eagerState()
 	} else {
 		s.state = tooHigh
 	}
@@ -387,45 +609,13 @@ func (s site) randomDelay(n int) {
 	time.Sleep(d)
 }
 
-func monitorTransaction(txn string) <-chan bool {
-	rv := make(chan bool)
-
-	go func() {
-		latest := time.After(time.Hour * 2)
-		t := time.NewTicker(time.Millisecond * 500)
-		defer log.Printf("No longer monitoring transaction %v", txn)
-		defer t.Stop()
-		defer close(rv)
-
-		for {
-			select {
-			case <-t.C:
-				tx, err := bc.GetRawTransaction(txn)
-				if err != nil {
-					log.Printf("Error getting transaction %v: %v",
-						txn, err)
-					return
-				}
-				if tx.Confirmations > 0 {
-					return
-				}
-			case <-latest:
-				log.Printf("Timed out monitoring %v", txn)
-				return
-			}
-		}
-	}()
-
-	log.Printf("Monitoring transaction %v", txn)
-	return rv
-}
-
 func (s site) monitor() {
 	tickers := map[int]*time.Ticker{
 		tooHigh:    time.NewTicker(durations[tooHigh]),
 		owned:      time.NewTicker(durations[owned]),
 		normal:     time.NewTicker(durations[normal]),
 		aggressive: time.NewTicker(durations[aggressive]),
+		sprint:     time.NewTicker(durations[sprint]),
 	}
 	var delay <-chan time.Time
 	var txnch <-chan bool
@@ -445,7 +635,7 @@ func (s site) monitor() {
 		}
 
 		if txnch == nil && s.pendingTx != "" && s.state != owned {
-			txnch = monitorTransaction(s.pendingTx)
+			txnch = txWatch.watch(s.pendingTx, s.name())
 		}
 
 		t := tickers[s.state].C
@@ -480,23 +670,63 @@ func readConf(fn string) {
 		log.Fatalf("Error parsing config: %v", err)
 	}
 
+	names := map[string]bool{}
 	for _, v := range conf.Notifications {
 		if _, ok := notifyFuns[v.Driver]; !ok {
 			log.Fatalf("Unknown driver '%s' in '%s'", v.Driver, v.Name)
 		}
+		if err := v.validate(); err != nil {
+			log.Fatalf("Invalid notifier '%s': %v", v.Name, err)
+		}
+		if names[v.Name] {
+			log.Fatalf("Duplicate notifier name '%s'", v.Name)
+		}
+		names[v.Name] = true
+	}
+
+	if err := conf.HTTP.validate(); err != nil {
+		log.Fatalf("Invalid http config: %v", err)
+	}
+
+	slugs := map[string]bool{}
+	for _, s := range conf.Sites {
+		if slugs[s.slug()] {
+			log.Fatalf("Duplicate site name '%s'", s.slug())
+		}
+		slugs[s.slug()] = true
+		switch s.OnBot {
+		case "", onBotYield, onBotCompete:
+		default:
+			log.Fatalf("Invalid on_bot '%s' for %v", s.OnBot, s.ReadURL)
+		}
+		if s.Fee != nil {
+			if err := s.Fee.validate(); err != nil {
+				log.Fatalf("Invalid fee policy for %v: %v", s.ReadURL, err)
+			}
+		}
+	}
+
+	if conf.Snapshots != nil {
+		if err := conf.Snapshots.validate(); err != nil {
+			log.Fatalf("Invalid snapshot config: %v", err)
+		}
 	}
 }
 
-func updateMyAddresses() error {
+func updateMyAddresses() (err error) {
+	defer func() { recordAddressUpdate(err) }()
+
 	atmp := map[string]bool{}
 
-	accts, err := bc.ListAccounts()
+	accts, err := listAccounts()
 	if err != nil {
 		return err
 	}
 
 	for a := range accts {
+		done := timeRPC("getaddressesbyaccount")
 		aa, err := bc.GetAddressesByAccount(a)
+		done()
 		if err != nil {
 			return err
 		}
@@ -505,7 +735,9 @@ func updateMyAddresses() error {
 		}
 	}
 
+	myAddressesMu.Lock()
 	myAddresses = atmp
+	myAddressesMu.Unlock()
 
 	return nil
 }
@@ -513,6 +745,12 @@ func updateMyAddresses() error {
 func main() {
 	httpBind := flag.String("http", ":8077",
 		"HTTP binding address (for status/listening")
+	gainsOnly := flag.Bool("gains", false,
+		"Write a capital gains report to stdout and exit")
+	ratesFile := flag.String("rates", "",
+		"CSV of date,rate fiat prices for the gains report")
+	gainsFilter := flag.String("gains-filter", "",
+		"Filter the gains report, as in /report/gains.csv (e.g. range=2013-05)")
 
 	flag.Parse()
 
@@ -521,13 +759,33 @@ func main() {
 	bc = bitcoin.NewBitcoindClient(conf.Bitcoin,
 		conf.BitcoinUser, conf.BitcoinPass)
 
+	trades.load(tradesFile)
+	owners.load(ownersFile)
+
+	if *gainsOnly {
+		printGains(*ratesFile, *gainsFilter)
+		return
+	}
+
 	if err := updateMyAddresses(); err != nil {
 		log.Fatalf("Can't update my addresses: %v", err)
 	}
+	go refreshAddresses()
 	go startHTTPServer(*httpBind)
 	go buyMonitor()
+	go txWatch.run()
+	go competitors.run()
+	watchPurchases()
+
+	go notify(conf.Notifications, time.Duration(conf.DedupWindow))
 
-	go notify(conf.Notifications)
+	if conf.Snapshots != nil {
+		go snapshots(conf.Snapshots)
+	}
+
+	for i := range conf.Sites {
+		siteStatuses.register(&conf.Sites[i])
+	}
 
 	for _, s := range conf.Sites {
 		if s.Disabled {
@@ -91,6 +91,51 @@ func TestPending(t *testing.T) {
 	}
 }
 
+func TestOwnerParsing(t *testing.T) {
+	tests := []struct {
+		filename string
+		name     string
+		link     string
+		address  string
+	}{
+		{"normal.html", "todos mios",
+			"http://blockchain.info/address/1GKiSjf6NYQgYYpMZjHSKDrA8mEUmJHyeD",
+			"1GKiSjf6NYQgYYpMZjHSKDrA8mEUmJHyeD"},
+		{"bears.html", "Simplypc-atl.com", "http://Simplypc-atl.com", ""},
+		{"goldbar.html", "nobody", "", ""},
+		{"pending.html", "hugo", "http://google.com", ""},
+	}
+
+	for _, test := range tests {
+		st, err := parseFile(t, "samples/"+test.filename)
+		if err != nil {
+			t.Errorf("Error parsing sample from %v: %v", test.filename, err)
+			continue
+		}
+		if st.OwnerName != test.name || st.OwnerLink != test.link ||
+			st.OwnerAddress != test.address {
+			t.Errorf("Expected owner %q/%q/%q from %v, got %q/%q/%q",
+				test.name, test.link, test.address, test.filename,
+				st.OwnerName, st.OwnerLink, st.OwnerAddress)
+		}
+	}
+}
+
+func TestOwnerBlock(t *testing.T) {
+	h := `Her current re-homing fee is 0.2988 bitcoins and is now housed by<br/>
+<a href="http://blockchain.info/address/1N4vdmKMRYu4FhbsxnWQBMu53EQEyZYe1C" target="_blank">Tom &amp; Jerry</a>`
+	name, link, addr := parseOwner(h)
+	if name != "Tom & Jerry" ||
+		link != "http://blockchain.info/address/1N4vdmKMRYu4FhbsxnWQBMu53EQEyZYe1C" ||
+		addr != "1N4vdmKMRYu4FhbsxnWQBMu53EQEyZYe1C" {
+		t.Errorf("Unexpected owner %q/%q/%q", name, link, addr)
+	}
+
+	if name, link, addr := parseOwner("It is worth 1 bitcoin"); name+link+addr != "" {
+		t.Errorf("Expected no owner, got %q/%q/%q", name, link, addr)
+	}
+}
+
 func TestAddressParsing(t *testing.T) {
 	a := `178sF7mXkMiGDKQWHjb1fVLEmzV4QSLrj`
 	tests := []string{
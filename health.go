@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxStale      = time.Hour
+	defaultMaxQueued     = 50
+	defaultMaxAddressAge = 3 * addressRefresh
+)
+
+// How long a health check waits on bitcoind before calling it down.
+var rpcCheckTimeout = 5 * time.Second
+
+// healthConf sets how far behind things may get before gembot
+// reports itself as unhealthy or not ready.
+type healthConf struct {
+	MaxStale      duration `json:"max_stale"`
+	MaxQueued     int      `json:"max_queued"`
+	MaxAddressAge duration `json:"max_address_age"`
+}
+
+var started = time.Now()
+
+// addressState tracks the outcome of updateMyAddresses.
+var addressState = struct {
+	sync.Mutex
+	updated time.Time
+	err     error
+}{}
+
+func recordAddressUpdate(err error) {
+	addressState.Lock()
+	defer addressState.Unlock()
+
+	addressState.err = err
+	if err == nil {
+		addressState.updated = time.Now()
+	}
+}
+
+type healthCheck struct {
+	OK     bool              `json:"ok"`
+	Detail string            `json:"detail,omitempty"`
+	Ages   map[string]string `json:"ages,omitempty"`
+}
+
+type healthReport struct {
+	OK     bool                   `json:"ok"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+func (r *healthReport) add(name string, err error) {
+	c := healthCheck{OK: err == nil}
+	if err != nil {
+		c.Detail = err.Error()
+		r.OK = false
+	}
+	r.Checks[name] = c
+}
+
+// addAges is add for checks that also report how old each of the
+// things they cover is.
+func (r *healthReport) addAges(name string, ages map[string]time.Duration, err error) {
+	r.add(name, err)
+	c := r.Checks[name]
+	c.Ages = map[string]string{}
+	for k, age := range ages {
+		c.Ages[k] = age.Truncate(time.Second).String()
+	}
+	r.Checks[name] = c
+}
+
+// redact drops error details, which may say more about our wallet
+// than anonymous callers should know.
+func (r *healthReport) redact() {
+	for name, c := range r.Checks {
+		c.Detail = ""
+		c.Ages = nil
+		r.Checks[name] = c
+	}
+}
+
+var probeBitcoind = func() error {
+	done := timeRPC("getbalance")
+	balance, err := bc.GetBalance()
+	done()
+	recordBalance(balance, err)
+	return err
+}
+
+// A bitcoindProbe is a single outstanding call to probeBitcoind.  err
+// is set before done is closed.
+type bitcoindProbe struct {
+	done chan bool
+	err  error
+}
+
+// Only one probe runs at a time, so a hung bitcoind costs us one
+// stuck goroutine rather than one per health poll.
+var bitcoindProbes = struct {
+	sync.Mutex
+	current *bitcoindProbe
+}{}
+
+func checkBitcoind() error {
+	bitcoindProbes.Lock()
+	p := bitcoindProbes.current
+	if p == nil {
+		p = &bitcoindProbe{done: make(chan bool)}
+		bitcoindProbes.current = p
+		go func() {
+			p.err = probeBitcoind()
+			bitcoindProbes.Lock()
+			bitcoindProbes.current = nil
+			bitcoindProbes.Unlock()
+			close(p.done)
+		}()
+	}
+	bitcoindProbes.Unlock()
+
+	select {
+	case <-p.done:
+		return p.err
+	case <-time.After(rpcCheckTimeout):
+		return fmt.Errorf("no response in %v", rpcCheckTimeout)
+	}
+}
+
+func checkAddresses(maxAge time.Duration, now time.Time) error {
+	addressState.Lock()
+	defer addressState.Unlock()
+
+	if addressState.updated.IsZero() {
+		if addressState.err != nil {
+			return addressState.err
+		}
+		return errors.New("addresses not loaded")
+	}
+	if age := now.Sub(addressState.updated); age > maxAge {
+		if addressState.err != nil {
+			return fmt.Errorf("addresses last updated %v ago: %v",
+				age.Truncate(time.Second), addressState.err)
+		}
+		return fmt.Errorf("addresses last updated %v ago", age.Truncate(time.Second))
+	}
+	return nil
+}
+
+// checkSiteStaleness returns how long ago each enabled site was last
+// checked successfully, failing if any of them is past maxStale.
+func checkSiteStaleness(sites []siteStatus, maxStale time.Duration,
+	now time.Time) (map[string]time.Duration, error) {
+
+	ages := map[string]time.Duration{}
+	var stale []string
+	for _, st := range sites {
+		if st.Disabled {
+			continue
+		}
+		since := st.LastSuccess
+		if since.IsZero() {
+			since = started
+		}
+		age := now.Sub(since)
+		ages[st.Name] = age
+		if age > maxStale {
+			stale = append(stale, fmt.Sprintf("%v last checked successfully %v ago",
+				st.Name, age.Truncate(time.Second)))
+		}
+	}
+	if len(stale) > 0 {
+		return ages, errors.New(strings.Join(stale, "; "))
+	}
+	return ages, nil
+}
+
+func checkNotificationQueue(maxQueued int) error {
+	incoming := notifyOutbox.queued()
+	notifyOutbox.mu.Lock()
+	n := len(notifyOutbox.Pending)
+	notifyOutbox.mu.Unlock()
+	if incoming > maxQueued {
+		return fmt.Errorf("%v notifications waiting to be routed", incoming)
+	}
+	if n > maxQueued {
+		return fmt.Errorf("%v notifications waiting for delivery", n)
+	}
+	return nil
+}
+
+// tradingHealth covers what's needed to trade at all.
+func tradingHealth() *healthReport {
+	maxAge := time.Duration(conf.Health.MaxAddressAge)
+	if maxAge == 0 {
+		maxAge = defaultMaxAddressAge
+	}
+
+	r := &healthReport{OK: true, Checks: map[string]healthCheck{}}
+	r.add("bitcoind", checkBitcoind())
+	r.add("addresses", checkAddresses(maxAge, time.Now()))
+	return r
+}
+
+// writeHealth reports health to anyone, but only explains failures
+// to those allowed to read.
+func writeHealth(w http.ResponseWriter, req *http.Request, r *healthReport) {
+	if !conf.HTTP.permits(req, roleRead) {
+		r.redact()
+	}
+	code := 200
+	if !r.OK {
+		code = 503
+	}
+	writeJSON(w, code, r)
+}
+
+func serveHealth(w http.ResponseWriter, req *http.Request) {
+	writeHealth(w, req, tradingHealth())
+}
+
+// serveReady additionally fails when sites or notifications are
+// falling behind.
+func serveReady(w http.ResponseWriter, req *http.Request) {
+	maxStale := time.Duration(conf.Health.MaxStale)
+	if maxStale == 0 {
+		maxStale = defaultMaxStale
+	}
+	maxQueued := conf.Health.MaxQueued
+	if maxQueued == 0 {
+		maxQueued = defaultMaxQueued
+	}
+
+	r := tradingHealth()
+	ages, err := checkSiteStaleness(siteStatuses.all(), maxStale, time.Now())
+	r.addAges("sites", ages, err)
+	r.add("notifications", checkNotificationQueue(maxQueued))
+	writeHealth(w, req, r)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSiteStaleness(t *testing.T) {
+	now := started.Add(2 * time.Hour)
+
+	tests := []struct {
+		sites []siteStatus
+		ok    bool
+	}{
+		{nil, true},
+		{[]siteStatus{{Name: "a", LastSuccess: now.Add(-time.Minute)}}, true},
+		{[]siteStatus{{Name: "a", LastSuccess: now.Add(-2 * time.Hour)}}, false},
+		{[]siteStatus{{Name: "a", LastSuccess: now.Add(-2 * time.Hour), Disabled: true}}, true},
+		{[]siteStatus{{Name: "never"}}, false},
+	}
+
+	for _, test := range tests {
+		_, err := checkSiteStaleness(test.sites, time.Hour, now)
+		if (err == nil) != test.ok {
+			t.Errorf("Expected ok=%v for %+v, got %v", test.ok, test.sites, err)
+		}
+	}
+
+	if _, err := checkSiteStaleness([]siteStatus{{Name: "new"}}, time.Hour,
+		started.Add(time.Minute)); err != nil {
+		t.Errorf("Expected a site not yet checked after startup to be fine: %v", err)
+	}
+
+	ages, err := checkSiteStaleness([]siteStatus{
+		{Name: "a", LastSuccess: now.Add(-2 * time.Hour)},
+		{Name: "b", LastSuccess: now.Add(-time.Minute)},
+		{Name: "c", LastSuccess: now.Add(-3 * time.Hour)},
+	}, time.Hour, now)
+	if len(ages) != 3 || ages["a"] != 2*time.Hour || ages["b"] != time.Minute {
+		t.Errorf("Expected the age of every site, got %v", ages)
+	}
+	if err == nil || !strings.Contains(err.Error(), "a last") ||
+		!strings.Contains(err.Error(), "c last") {
+		t.Errorf("Expected both stale sites named, got %v", err)
+	}
+}
+
+func TestCheckBitcoindHung(t *testing.T) {
+	prev, prevTimeout := probeBitcoind, rpcCheckTimeout
+	defer func() { probeBitcoind, rpcCheckTimeout = prev, prevTimeout }()
+	rpcCheckTimeout = 10 * time.Millisecond
+
+	var mu sync.Mutex
+	probes := 0
+	release := make(chan bool)
+	probeBitcoind = func() error {
+		mu.Lock()
+		probes++
+		mu.Unlock()
+		<-release
+		return nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := checkBitcoind(); err == nil {
+			t.Fatalf("Expected a hung bitcoind to be unhealthy")
+		}
+	}
+	mu.Lock()
+	if probes != 1 {
+		t.Errorf("Expected one probe while bitcoind hangs, got %v", probes)
+	}
+	mu.Unlock()
+
+	close(release)
+	rpcCheckTimeout = time.Second
+	for i := 0; i < 100; i++ {
+		if err := checkBitcoind(); err == nil {
+			return
+		}
+	}
+	t.Errorf("Expected bitcoind to recover")
+}
+
+func TestCheckAddresses(t *testing.T) {
+	defer func() {
+		addressState.updated, addressState.err = time.Time{}, nil
+	}()
+	now := time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	addressState.updated, addressState.err = time.Time{}, nil
+	if err := checkAddresses(time.Hour, now); err == nil {
+		t.Errorf("Expected addresses never loaded to be unhealthy")
+	}
+
+	recordAddressUpdate(nil)
+	addressState.updated = now.Add(-time.Minute)
+	recordAddressUpdate(errors.New("wallet gone"))
+	if err := checkAddresses(time.Hour, now); err != nil {
+		t.Errorf("Expected a recent update to be healthy: %v", err)
+	}
+
+	err := checkAddresses(time.Hour, now.Add(2*time.Hour))
+	if err == nil || !strings.Contains(err.Error(), "wallet gone") {
+		t.Errorf("Expected a stale update to be unhealthy, got %v", err)
+	}
+}
+
+func TestHealthDetail(t *testing.T) {
+	old := conf.HTTP
+	defer func() { conf.HTTP = old }()
+	conf.HTTP = httpConf{Users: []httpUser{{Name: "r", Password: "p", Role: "read"}}}
+
+	report := func() *healthReport {
+		r := &healthReport{OK: true, Checks: map[string]healthCheck{}}
+		r.add("bitcoind", errors.New("secret wallet error"))
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	writeHealth(w, httptest.NewRequest("GET", "/healthz", nil), report())
+	if w.Code != 503 || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("Expected a bare 503 for anonymous callers, got %v: %v", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	req.SetBasicAuth("r", "p")
+	writeHealth(w, req, report())
+	if w.Code != 503 || !strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("Expected details for readers, got %v: %v", w.Code, w.Body)
+	}
+}
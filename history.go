@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const maxHistory = 200
+
+const (
+	statusQueued     = "queued"
+	statusRetrying   = "retrying"
+	statusSent       = "sent"
+	statusDead       = "dead"
+	statusSuppressed = "suppressed"
+	statusDropped    = "dropped"
+	statusFailed     = "failed"
+)
+
+// delivery is the state of a notification as far as one notifier is
+// concerned.
+type delivery struct {
+	Status   string    `json:"status"`
+	Attempts int       `json:"attempts,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Updated  time.Time `json:"updated"`
+}
+
+type historyEntry struct {
+	Time       time.Time            `json:"time"`
+	Note       notification         `json:"note"`
+	Deliveries map[string]*delivery `json:"deliveries"`
+}
+
+// record adds a notification to the history, returning it with its
+// id assigned.
+func (o *outbox) record(note notification, now time.Time) notification {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	note.ID = newID()
+	o.History = append(o.History, &historyEntry{
+		Time:       now,
+		Note:       note,
+		Deliveries: map[string]*delivery{},
+	})
+	if len(o.History) > maxHistory {
+		o.History = o.History[len(o.History)-maxHistory:]
+	}
+	o.persist()
+	return note
+}
+
+// Must be called with the lock held.
+func (o *outbox) track(id, name, status string, attempts int, err error) {
+	for i := len(o.History) - 1; i >= 0; i-- {
+		h := o.History[i]
+		if h.Note.ID != id {
+			continue
+		}
+		d := &delivery{Status: status, Attempts: attempts, Updated: time.Now()}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		h.Deliveries[name] = d
+		return
+	}
+}
+
+func (o *outbox) setStatus(id, name, status string, attempts int, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.track(id, name, status, attempts, err)
+	o.persist()
+}
+
+// recent returns up to n of the most recent notifications, newest
+// first.
+func (o *outbox) recent(n int) []historyEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	rv := []historyEntry{}
+	for i := len(o.History) - 1; i >= 0 && len(rv) < n; i-- {
+		h := *o.History[i]
+		h.Deliveries = map[string]*delivery{}
+		for k, v := range o.History[i].Deliveries {
+			d := *v
+			h.Deliveries[k] = &d
+		}
+		rv = append(rv, h)
+	}
+	return rv
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	e := json.NewEncoder(w)
+	e.Encode(v)
+}
+
+func listNotifications(w http.ResponseWriter, req *http.Request) {
+	limit := maxHistory
+	if l := req.FormValue("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			http.Error(w, "invalid limit", 400)
+			return
+		}
+	}
+	writeJSON(w, 200, notifyOutbox.recent(limit))
+}
+
+func findNotifier(name string) (notifier, bool) {
+	for _, n := range conf.Notifications {
+		if n.Name == name {
+			return n, true
+		}
+	}
+	return notifier{}, false
+}
+
+// testNotification synchronously sends a synthetic notification
+// through a single notifier so configuration problems show up right
+// away rather than after a trade.
+func testNotification(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "POST required", 405)
+		return
+	}
+
+	name := req.FormValue("name")
+	n, ok := findNotifier(name)
+	if !ok {
+		http.Error(w, "no such notifier: "+name, 404)
+		return
+	}
+	if n.Disabled {
+		http.Error(w, "notifier is disabled: "+name, 409)
+		return
+	}
+
+	note := notifyOutbox.record(notification{
+		Event:    "Test",
+		Msg:      "Test notification from gembot via " + name,
+		Severity: sevInfo,
+	}, time.Now())
+	note.Delivery = newID()
+
+	err := notifyFuns[n.Driver](n, note)
+	countDelivery(n, err)
+
+	status, code := statusSent, 200
+	if err != nil {
+		status, code = statusFailed, 502
+	}
+
+	notifyOutbox.setStatus(note.ID, name, status, 1, err)
+
+	rv := map[string]string{"notifier": name, "status": status}
+	if err != nil {
+		rv["error"] = err.Error()
+	}
+	writeJSON(w, code, rv)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListNotifications(t *testing.T) {
+	old := notifyOutbox
+	defer func() { notifyOutbox = old }()
+	notifyOutbox = &outbox{wake: map[string]chan bool{}}
+
+	for _, e := range []string{"first", "second", "third"} {
+		notifyOutbox.record(notification{Event: e}, started)
+	}
+
+	tests := []struct {
+		params string
+		code   int
+		exp    []string
+	}{
+		{"", 200, []string{"third", "second", "first"}},
+		{"?limit=2", 200, []string{"third", "second"}},
+		{"?limit=0", 200, nil},
+		{"?limit=-1", 400, nil},
+		{"?limit=lots", 400, nil},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		listNotifications(w, httptest.NewRequest("GET", "/notifications"+test.params, nil))
+		if w.Code != test.code {
+			t.Errorf("%q: expected %v, got %v: %v", test.params, test.code, w.Code, w.Body)
+			continue
+		}
+		if w.Code != 200 {
+			continue
+		}
+		var got []historyEntry
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Errorf("%q: error decoding %v: %v", test.params, w.Body, err)
+			continue
+		}
+		if len(got) != len(test.exp) {
+			t.Errorf("%q: expected %v, got %+v", test.params, test.exp, got)
+			continue
+		}
+		for i, h := range got {
+			if h.Note.Event != test.exp[i] {
+				t.Errorf("%q: expected %v, got %+v", test.params, test.exp, got)
+				break
+			}
+		}
+	}
+}
+
+func TestTestNotification(t *testing.T) {
+	oldOutbox, oldNotifiers := notifyOutbox, conf.Notifications
+	defer func() {
+		notifyOutbox, conf.Notifications = oldOutbox, oldNotifiers
+		delete(notifyFuns, "fake")
+	}()
+	notifyOutbox = &outbox{wake: map[string]chan bool{}}
+
+	var sent []string
+	notifyFuns["fake"] = func(n notifier, note notification) error {
+		sent = append(sent, n.Name)
+		if n.Config["fail"] != "" {
+			return errors.New(n.Config["fail"])
+		}
+		return nil
+	}
+	conf.Notifications = []notifier{
+		{Name: "good", Driver: "fake"},
+		{Name: "bad", Driver: "fake", Config: map[string]string{"fail": "no route"}},
+		{Name: "off", Driver: "fake", Disabled: true},
+	}
+
+	tests := []struct {
+		method, name string
+		code         int
+		status       string
+	}{
+		{"GET", "good", 405, ""},
+		{"POST", "good", 200, statusSent},
+		{"POST", "bad", 502, statusFailed},
+		{"POST", "off", 409, ""},
+		{"POST", "nosuch", 404, ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		testNotification(w, httptest.NewRequest(test.method,
+			"/notifications/test?name="+test.name, nil))
+		if w.Code != test.code {
+			t.Errorf("%v %v: expected %v, got %v: %v",
+				test.method, test.name, test.code, w.Code, w.Body)
+			continue
+		}
+		if test.status == "" {
+			continue
+		}
+		var rv map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &rv); err != nil || rv["status"] != test.status {
+			t.Errorf("%v: expected status %v, got %v (%v)", test.name, test.status, w.Body, err)
+		}
+	}
+
+	if len(sent) != 2 || sent[0] != "good" || sent[1] != "bad" {
+		t.Errorf("Expected only good and bad to be sent to, got %v", sent)
+	}
+	h := notifyOutbox.recent(10)
+	if len(h) != 2 || h[0].Deliveries["bad"].Status != statusFailed ||
+		h[1].Deliveries["good"].Status != statusSent {
+		t.Errorf("Expected both tests in the history, got %+v", h)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+// accounting configures how wallet transactions map onto accounts in
+// the ledger and beancount exports.  Anything left empty gets a
+// sensible default.
+type accounting struct {
+	Wallet     string // prefix for wallet accounts
+	Fees       string
+	Expenses   string // prefix for per-site purchase accounts
+	Income     string // prefix for per-site sale accounts
+	Unassigned string // counterpart of transactions with no site
+	Commodity  string
+}
+
+func (a accounting) withDefaults() accounting {
+	def := func(s *string, d string) {
+		if *s == "" {
+			*s = d
+		}
+	}
+	def(&a.Wallet, "Assets:Bitcoin")
+	def(&a.Fees, "Expenses:Bitcoin:Fees")
+	def(&a.Expenses, "Expenses:Gems")
+	def(&a.Income, "Income:Gems")
+	def(&a.Unassigned, "Equity:Bitcoin:Unassigned")
+	def(&a.Commodity, "BTC")
+	return a
+}
+
+type posting struct {
+	Account string
+	Amount  bitcoin.Amount
+}
+
+type entry struct {
+	walletTx
+	Payee    string
+	Postings []posting
+}
+
+func findSiteByName(name string) *site {
+	for i := range conf.Sites {
+		if conf.Sites[i].name() == name {
+			return &conf.Sites[i]
+		}
+	}
+	return nil
+}
+
+func walletAccount(a accounting, acct string) string {
+	if acct == "" {
+		acct = "Default"
+	}
+	return a.Wallet + ":" + acct
+}
+
+// accountComponent turns a site name (often a URL) into something
+// usable as part of an account name.
+func accountComponent(name string) string {
+	if u, err := url.Parse(name); err == nil && u.Host != "" {
+		name = u.Host + strings.TrimRight(u.Path, "/")
+	}
+	return strings.Replace(name, ":", "-", -1)
+}
+
+// counterAccount is the account on the other side of the wallet for
+// the given transaction.
+func counterAccount(a accounting, t walletTx) string {
+	if t.Site == "" {
+		return a.Unassigned
+	}
+	s := findSiteByName(t.Site)
+	if t.Amount > 0 {
+		if s != nil && s.IncomeAccount != "" {
+			return s.IncomeAccount
+		}
+		return a.Income + ":" + accountComponent(t.Site)
+	}
+	if s != nil && s.ExpenseAccount != "" {
+		return s.ExpenseAccount
+	}
+	return a.Expenses + ":" + accountComponent(t.Site)
+}
+
+// toEntry maps a wallet transaction to balanced postings: purchases
+// move value from the wallet to the site's expense account, sales
+// from the site's income account into the wallet, and fees get a
+// posting of their own.
+func toEntry(a accounting, t walletTx) entry {
+	e := entry{walletTx: t, Payee: t.Site}
+	if e.Payee == "" {
+		e.Payee = t.Comment
+	}
+	if e.Payee == "" {
+		e.Payee = "Bitcoin " + t.Dir
+	}
+
+	fee := t.Fee
+	if fee < 0 {
+		fee = -fee
+	}
+
+	e.Postings = append(e.Postings,
+		posting{counterAccount(a, t), -t.Amount})
+	if fee > 0 {
+		e.Postings = append(e.Postings, posting{a.Fees, fee})
+	}
+	e.Postings = append(e.Postings,
+		posting{walletAccount(a, t.Account), t.Amount - fee})
+
+	return e
+}
+
+func formatBTC(a bitcoin.Amount) string {
+	sign := ""
+	n := int64(a)
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	return fmt.Sprintf("%s%d.%08d", sign, n/1e8, n%1e8)
+}
+
+func writeLedgerEntry(w io.Writer, a accounting, t walletTx) error {
+	e := toEntry(a, t)
+	fmt.Fprintf(w, "%s * %s\n", t.Time.Format("2006/01/02"), e.Payee)
+	fmt.Fprintf(w, "    ; txid: %s\n", t.TXID)
+	if t.Comment != "" {
+		fmt.Fprintf(w, "    ; comment: %s\n", t.Comment)
+	}
+	for _, p := range e.Postings {
+		fmt.Fprintf(w, "    %-40s  %s %s\n", p.Account,
+			formatBTC(p.Amount), a.Commodity)
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+func writeLedger(w io.Writer, a accounting, tlist txlist) {
+	for _, t := range tlist {
+		writeLedgerEntry(w, a, t)
+	}
+}
+
+// beancountAccount coerces an account name into beancount's
+// stricter syntax, where each component must start with a capital
+// letter and contain only letters, digits and dashes.
+func beancountAccount(acct string) string {
+	parts := strings.Split(acct, ":")
+	for i, p := range parts {
+		rs := []rune(p)
+		for j, r := range rs {
+			if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-') {
+				rs[j] = '-'
+			}
+		}
+		if len(rs) == 0 || !unicode.IsLetter(rs[0]) {
+			rs = append([]rune{'X'}, rs...)
+		}
+		rs[0] = unicode.ToUpper(rs[0])
+		parts[i] = string(rs)
+	}
+	return strings.Join(parts, ":")
+}
+
+func writeBeancount(w io.Writer, a accounting, tlist txlist) {
+	var entries []entry
+	opened := map[string]bool{}
+	var accounts []string
+	for _, t := range tlist {
+		e := toEntry(a, t)
+		for i := range e.Postings {
+			acct := beancountAccount(e.Postings[i].Account)
+			e.Postings[i].Account = acct
+			if !opened[acct] {
+				opened[acct] = true
+				accounts = append(accounts, acct)
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	if len(entries) > 0 {
+		sort.Strings(accounts)
+		d := entries[0].Time.Format("2006-01-02")
+		for _, acct := range accounts {
+			fmt.Fprintf(w, "%s open %s\n", d, acct)
+		}
+		fmt.Fprintln(w)
+	}
+
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s * %q %q\n", e.Time.Format("2006-01-02"),
+			e.Payee, e.Comment)
+		fmt.Fprintf(w, "  txid: %q\n", e.TXID)
+		for _, p := range e.Postings {
+			fmt.Fprintf(w, "  %-40s  %s %s\n", p.Account,
+				formatBTC(p.Amount), a.Commodity)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func exportLedger(w http.ResponseWriter, req *http.Request) {
+	s, ok := openStream(w, req)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(200)
+
+	a := conf.Accounting.withDefaults()
+	logExportError(s.each(func(t walletTx) error {
+		return writeLedgerEntry(w, a, t)
+	}))
+}
+
+// Beancount wants accounts opened before use, so unlike the other
+// exports this one needs to see everything before writing.
+func exportBeancount(w http.ResponseWriter, req *http.Request) {
+	f, err := parseTxFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	tlist, err := walletTransactions(f)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(200)
+	writeBeancount(w, conf.Accounting.withDefaults(), tlist)
+}
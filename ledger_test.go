@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+var ledgerTxns = txlist{
+	{
+		Time:    time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC),
+		Account: "gems",
+		Dir:     "out",
+		Amount:  -182000000,
+		Fee:     -50000,
+		TXID:    "abc",
+		Site:    "bears",
+	},
+	{
+		Time:    time.Date(2013, 5, 3, 12, 0, 0, 0, time.UTC),
+		Account: "",
+		Dir:     "in",
+		Comment: "sold",
+		Amount:  200000000,
+		TXID:    "def",
+		Site:    "bears",
+	},
+}
+
+func TestLedger(t *testing.T) {
+	exp := `2013/05/01 * bears
+    ; txid: abc
+    Expenses:Gems:bears                       1.82000000 BTC
+    Expenses:Bitcoin:Fees                     0.00050000 BTC
+    Assets:Bitcoin:gems                       -1.82050000 BTC
+
+2013/05/03 * bears
+    ; txid: def
+    ; comment: sold
+    Income:Gems:bears                         -2.00000000 BTC
+    Assets:Bitcoin:Default                    2.00000000 BTC
+
+`
+	buf := &bytes.Buffer{}
+	writeLedger(buf, accounting{}.withDefaults(), ledgerTxns)
+	if buf.String() != exp {
+		t.Errorf("Expected:\n%s\nGot:\n%s", exp, buf.String())
+	}
+}
+
+func TestBeancount(t *testing.T) {
+	exp := `2013-05-01 open Assets:Bitcoin:Default
+2013-05-01 open Assets:Bitcoin:Gems
+2013-05-01 open Expenses:Bitcoin:Fees
+2013-05-01 open Expenses:Gems:Bears
+2013-05-01 open Income:Gems:Bears
+
+2013-05-01 * "bears" ""
+  txid: "abc"
+  Expenses:Gems:Bears                       1.82000000 BTC
+  Expenses:Bitcoin:Fees                     0.00050000 BTC
+  Assets:Bitcoin:Gems                       -1.82050000 BTC
+
+2013-05-03 * "bears" "sold"
+  txid: "def"
+  Income:Gems:Bears                         -2.00000000 BTC
+  Assets:Bitcoin:Default                    2.00000000 BTC
+
+`
+	buf := &bytes.Buffer{}
+	writeBeancount(buf, accounting{}.withDefaults(), ledgerTxns)
+	if buf.String() != exp {
+		t.Errorf("Expected:\n%s\nGot:\n%s", exp, buf.String())
+	}
+}
+
+func TestAccountComponent(t *testing.T) {
+	tests := []struct{ in, exp string }{
+		{"http://example.com/", "example.com"},
+		{"https://example.com:8080/gem/", "example.com-8080/gem"},
+		{"bears", "bears"},
+	}
+	for _, test := range tests {
+		if got := accountComponent(test.in); got != test.exp {
+			t.Errorf("Expected %q for %q, got %q", test.exp, test.in, got)
+		}
+	}
+}
+
+func TestBeancountAccount(t *testing.T) {
+	tests := []struct{ in, exp string }{
+		{"Expenses:Gems:example.com/gem", "Expenses:Gems:Example-com-gem"},
+		{"Assets:Bitcoin:1st", "Assets:Bitcoin:X1st"},
+		{"Income:Gems:bears", "Income:Gems:Bears"},
+	}
+	for _, test := range tests {
+		if got := beancountAccount(test.in); got != test.exp {
+			t.Errorf("Expected %q for %q, got %q", test.exp, test.in, got)
+		}
+	}
+}
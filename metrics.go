@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A tiny implementation of the prometheus text format, enough for
+// the handful of counters, gauges and histograms gembot exposes.
+
+type metric interface {
+	write(w io.Writer)
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatLabels(names []string, values []string, extra ...string) string {
+	var parts []string
+	for i, n := range names {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, n, escapeLabel(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabel(extra[i+1])))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return fmt.Sprintf("%g", f)
+}
+
+type counterVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newCounter(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels,
+		values: map[string]float64{}, keys: map[string][]string{}}
+	registry = append(registry, c)
+	return c
+}
+
+func (c *counterVec) add(v float64, lvs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := strings.Join(lvs, "\x00")
+	c.values[k] += v
+	c.keys[k] = lvs
+}
+
+func (c *counterVec) inc(lvs ...string) {
+	c.add(1, lvs...)
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name,
+			formatLabels(c.labels, c.keys[k]), formatFloat(c.values[k]))
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	values  map[string]*histogram
+	keys    map[string][]string
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets,
+		values: map[string]*histogram{}, keys: map[string][]string{}}
+	registry = append(registry, h)
+	return h
+}
+
+func (h *histogramVec) observe(v float64, lvs ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k := strings.Join(lvs, "\x00")
+	x, ok := h.values[k]
+	if !ok {
+		x = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = x
+		h.keys[k] = lvs
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			x.counts[i]++
+		}
+	}
+	x.sum += v
+	x.count++
+}
+
+func (h *histogramVec) since(start time.Time, lvs ...string) {
+	h.observe(time.Since(start).Seconds(), lvs...)
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		x, lvs := h.values[k], h.keys[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+				formatLabels(h.labels, lvs, "le", formatFloat(b)), x.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+			formatLabels(h.labels, lvs, "le", "+Inf"), x.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name,
+			formatLabels(h.labels, lvs), formatFloat(x.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name,
+			formatLabels(h.labels, lvs), x.count)
+	}
+}
+
+// gaugeFunc computes its values when scraped.
+type gaugeFunc struct {
+	name   string
+	help   string
+	labels []string
+	fn     func(set func(v float64, lvs ...string))
+}
+
+func newGaugeFunc(name, help string, fn func(set func(v float64, lvs ...string)), labels ...string) {
+	registry = append(registry, &gaugeFunc{name, help, labels, fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	g.fn(func(v float64, lvs ...string) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, lvs), formatFloat(v))
+	})
+}
+
+var registry []metric
+
+var (
+	checkBuckets = []float64{.1, .25, .5, 1, 2.5, 5, 10, 30}
+	rpcBuckets   = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+	mChecks = newCounter("gembot_checks_total",
+		"Site checks performed.", "site")
+	mParseFailures = newCounter("gembot_parse_failures_total",
+		"Site pages that couldn't be parsed.", "site")
+	mFetchErrors = newCounter("gembot_fetch_errors_total",
+		"Failed site fetches by HTTP status, or error if there was none.",
+		"site", "status")
+	mBuyAttempts = newCounter("gembot_buy_attempts_total",
+		"Attempts to buy from a site.", "site")
+	mBuySuccesses = newCounter("gembot_buy_successes_total",
+		"Successful purchases from a site.", "site")
+	mBuyBlocks = newCounter("gembot_buy_blocks_total",
+		"Purchases not attempted, by reason.", "site", "reason")
+	mNotifySent = newCounter("gembot_notifications_sent_total",
+		"Notifications delivered, by driver.", "driver")
+	mNotifyFailed = newCounter("gembot_notifications_failed_total",
+		"Notification delivery attempts that failed, by driver.", "driver")
+	mCheckLatency = newHistogram("gembot_check_duration_seconds",
+		"Time taken to check a site.", checkBuckets, "site")
+	mRPCLatency = newHistogram("gembot_wallet_rpc_duration_seconds",
+		"Time taken by bitcoind RPC calls.", rpcBuckets, "method")
+)
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func init() {
+	perSite := func(f func(st siteStatus) float64) func(func(float64, ...string)) {
+		return func(set func(float64, ...string)) {
+			for _, st := range siteStatuses.all() {
+				set(f(st), st.Name)
+			}
+		}
+	}
+	newGaugeFunc("gembot_site_value_btc", "Current value of a site's gem.",
+		perSite(func(st siteStatus) float64 { return btc(st.Value) }), "site")
+	newGaugeFunc("gembot_site_threshold_btc", "Price at or below which we buy.",
+		perSite(func(st siteStatus) float64 { return btc(st.Threshold) }), "site")
+	newGaugeFunc("gembot_site_mine", "Whether we own a site's gem.",
+		perSite(func(st siteStatus) float64 { return boolGauge(st.IsMine) }), "site")
+	newGaugeFunc("gembot_site_locked", "Whether buying from a site is locked.",
+		perSite(func(st siteStatus) float64 { return boolGauge(st.Locked) }), "site")
+	newGaugeFunc("gembot_site_polling_state", "The polling state a site is in.",
+		func(set func(float64, ...string)) {
+			var names []string
+			for _, name := range stateNames {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, st := range siteStatuses.all() {
+				for _, name := range names {
+					set(boolGauge(st.State == name), st.Name, name)
+				}
+			}
+		}, "site", "state")
+}
+
+// timeRPC starts timing a wallet RPC, returning a func to call when
+// it's done.
+func timeRPC(method string) func() {
+	start := time.Now()
+	return func() { mRPCLatency.since(start, method) }
+}
+
+// blockReason maps buy manager errors onto metric labels.
+func blockReason(err error) string {
+	switch err {
+	case maybeOwned:
+		return "maybe_owned"
+	case insufficientFunds:
+		return "insufficient_funds"
+	case feeTooHigh:
+		return "fee_too_high"
+	}
+	return "error"
+}
+
+func countDelivery(n notifier, err error) {
+	if err == nil {
+		mNotifySent.inc(n.Driver)
+	} else {
+		mNotifyFailed.inc(n.Driver)
+	}
+}
+
+func serveMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range registry {
+		m.write(w)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	c := &counterVec{name: "x_total", help: "Things.", labels: []string{"site"},
+		values: map[string]float64{}, keys: map[string][]string{}}
+	c.inc(`a"b`)
+	c.add(2, "c")
+
+	h := &histogramVec{name: "y_seconds", help: "Time.", labels: []string{"m"},
+		buckets: []float64{.1, 1}, values: map[string]*histogram{},
+		keys: map[string][]string{}}
+	h.observe(.05, "get")
+	h.observe(.5, "get")
+	h.observe(5, "get")
+
+	buf := &bytes.Buffer{}
+	c.write(buf)
+	h.write(buf)
+
+	exp := `# HELP x_total Things.
+# TYPE x_total counter
+x_total{site="a\"b"} 1
+x_total{site="c"} 2
+# HELP y_seconds Time.
+# TYPE y_seconds histogram
+y_seconds_bucket{m="get",le="0.1"} 1
+y_seconds_bucket{m="get",le="1"} 2
+y_seconds_bucket{m="get",le="+Inf"} 3
+y_seconds_sum{m="get"} 5.55
+y_seconds_count{m="get"} 3
+`
+	if buf.String() != exp {
+		t.Errorf("Expected:\n%s\nGot:\n%s", exp, buf.String())
+	}
+}
+
+func TestSiteGauges(t *testing.T) {
+	old := siteStatuses
+	defer func() { siteStatuses = old }()
+
+	siteStatuses = &statusBoard{}
+	siteStatuses.register(&site{Name: "bears", Threshold: 50000000})
+
+	buf := &bytes.Buffer{}
+	for _, m := range registry {
+		m.write(buf)
+	}
+
+	for _, exp := range []string{
+		`gembot_site_threshold_btc{site="bears"} 0.5`,
+		`gembot_site_mine{site="bears"} 0`,
+		`gembot_site_polling_state{site="bears",state="normal"} 1`,
+		`gembot_site_polling_state{site="bears",state="owned"} 0`,
+	} {
+		if !strings.Contains(buf.String(), exp+"\n") {
+			t.Errorf("Expected metrics to contain %q", exp)
+		}
+	}
+}
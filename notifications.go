@@ -1,34 +1,44 @@
 package main
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/dustin/go-nma"
 	"github.com/rem7/goprowl"
 )
 
-const max_retries = 10
-
-var notifyCh = make(chan notification, 10)
-
 type notifier struct {
 	Name     string
 	Driver   string
 	Event    string
+	Events   []string
+	Severity string
+	Sites    []string
+	Quiet    *quietHours
+	Limit    *rateLimit
 	Disabled bool
 	Config   map[string]string
 }
 
 type notification struct {
-	Event string `json:"event"`
-	Msg   string `json:"msg"`
+	ID       string `json:"id,omitempty"`
+	Event    string `json:"event"`
+	Msg      string `json:"msg"`
+	Severity string `json:"severity,omitempty"`
+	Site     string `json:"site,omitempty"`
+
+	// Identifies a single delivery to a single notifier, stable
+	// across retries.
+	Delivery string `json:"-"`
 }
 
 type notifyFun func(n notifier, note notification) error
@@ -71,14 +81,37 @@ func notifyProwl(n notifier, note notification) (err error) {
 	return p.Push(&msg)
 }
 
+// signWebhook computes the signature of a webhook body.  The
+// timestamp and delivery id are covered so a receiver can reject
+// stale or replayed deliveries.
+func signWebhook(secret string, ts int64, id string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(h, "%d.%s.", ts, id)
+	h.Write(body)
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
+
 func notifyWebhook(n notifier, note notification) (err error) {
 	data, err := json.Marshal(note)
 	if err != nil {
 		return
 	}
 
-	r, err := http.Post(n.Config["url"], "application/json",
-		strings.NewReader(string(data)))
+	req, err := http.NewRequest("POST", n.Config["url"], bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gembot-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-Gembot-Delivery", note.Delivery)
+	if secret := n.Config["secret"]; secret != "" {
+		req.Header.Set("X-Gembot-Signature",
+			signWebhook(secret, ts, note.Delivery, data))
+	}
+
+	r, err := http.DefaultClient.Do(req)
 	if err == nil {
 		defer r.Body.Close()
 		if r.StatusCode < 200 || r.StatusCode >= 300 {
@@ -88,27 +121,45 @@ func notifyWebhook(n notifier, note notification) (err error) {
 	return
 }
 
-func (n notifier) notify(note notification) {
-	if n.Disabled {
-		return
-	}
-	log.Printf("Sending notification:  %v", note)
-	for i := 0; i < max_retries; i++ {
-		if err := notifyFuns[n.Driver](n, note); err == nil {
-			break
-		} else {
-			time.Sleep(1 * time.Second)
-			log.Printf("Retrying notification %s due to %v", n.Name, err)
+// postNotification queues a notification without ever blocking the
+// caller.  It's kept in order, and written to disk as soon as the
+// notify goroutine routes it; only a crash in between loses it.
+func postNotification(note notification) {
+	notifyOutbox.post(note)
+}
+
+func notify(notifiers []notifier, window time.Duration) {
+	notifyOutbox.load(outboxFile, notifiers)
+
+	for _, n := range notifiers {
+		if !n.Disabled {
+			go notifyOutbox.worker(n)
 		}
 	}
-}
 
-func notify(notifiers []notifier) {
-	for note := range notifyCh {
-		for _, n := range notifiers {
-			if n.Event == "" || n.Event == note.Event {
-				go n.notify(note)
+	dd := newDeduper(window)
+	flushTicker := time.NewTicker(time.Second)
+	defer flushTicker.Stop()
+	digestTicker := time.NewTicker(time.Minute)
+	defer digestTicker.Stop()
+
+	for {
+		select {
+		case <-notifyOutbox.incoming:
+			for _, note := range notifyOutbox.takeIncoming() {
+				now := time.Now()
+				for _, n := range dd.observe(note, now) {
+					route(notifiers, n, now)
+				}
+			}
+			notifyOutbox.hold(dd.held())
+		case now := <-flushTicker.C:
+			for _, note := range dd.flush(now) {
+				route(notifiers, note, now)
 			}
+			notifyOutbox.hold(dd.held())
+		case now := <-digestTicker.C:
+			sendDigests(notifiers, now)
 		}
 	}
 }
@@ -119,5 +170,5 @@ func notifySwitch(port int, onoff string, after time.Duration) {
 		Msg: fmt.Sprintf("Laundry device %v changed to %v after %s",
 			port, onoff, after),
 	}
-	notifyCh <- msg
+	postNotification(msg)
 }
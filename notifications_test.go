@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestSignWebhook(t *testing.T) {
+	exp := "sha256=6c246ded2298f91aecf07778005a2caebba2a7f4b2d09c6b185310ce3ba3ffc5"
+	got := signWebhook("sekrit", 1367366400, "abc123", []byte(`{"event":"e","msg":"m"}`))
+	if got != exp {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+}
+
+func TestSignedWebhook(t *testing.T) {
+	var hdr http.Header
+	var body []byte
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer s.Close()
+
+	n := notifier{Config: map[string]string{"url": s.URL, "secret": "sekrit"}}
+	err := notifyWebhook(n, notification{Event: "e", Msg: "m", Delivery: "d1"})
+	if err != nil {
+		t.Fatalf("Error sending webhook: %v", err)
+	}
+
+	if hdr.Get("X-Gembot-Delivery") != "d1" {
+		t.Errorf("Expected delivery d1, got %q", hdr.Get("X-Gembot-Delivery"))
+	}
+	ts, err := strconv.ParseInt(hdr.Get("X-Gembot-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatalf("Invalid timestamp %q", hdr.Get("X-Gembot-Timestamp"))
+	}
+	if exp := signWebhook("sekrit", ts, "d1", body); hdr.Get("X-Gembot-Signature") != exp {
+		t.Errorf("Expected signature %v, got %v", exp, hdr.Get("X-Gembot-Signature"))
+	}
+}
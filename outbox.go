@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const outboxFile = ",outbox.json"
+
+const (
+	minBackoff  = time.Second
+	maxBackoff  = time.Hour
+	maxAttempts = 15
+	maxDead     = 500
+)
+
+// An outboxEntry is a single notification destined for a single
+// notifier.
+type outboxEntry struct {
+	ID       string       `json:"id"`
+	Notifier string       `json:"notifier"`
+	Note     notification `json:"note"`
+	Created  time.Time    `json:"created"`
+	Attempts int          `json:"attempts"`
+	Next     time.Time    `json:"next"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// The outbox holds every undelivered notification on disk so they
+// survive restarts.  Each notifier has its own worker draining its
+// entries, so one broken driver can't hold up the others.
+type outbox struct {
+	mu      sync.Mutex
+	fn      string
+	Pending []*outboxEntry `json:"pending"`
+	Dead    []*outboxEntry `json:"dead"`
+
+	// Repeats the deduper was holding back when we last saved, as
+	// the summaries it would have sent.
+	Held []notification `json:"held,omitempty"`
+
+	// Notifications held back during quiet hours, by notifier.
+	Suppressed map[string][]notification `json:"suppressed,omitempty"`
+
+	// Recently routed notifications, oldest first.
+	History []*historyEntry `json:"history,omitempty"`
+
+	wake map[string]chan bool
+
+	// Posted notifications that haven't been routed yet.  These have
+	// their own lock so posting never waits on the disk or on
+	// anything else holding mu.
+	qmu      sync.Mutex
+	queue    []notification
+	incoming chan bool
+}
+
+var notifyOutbox = &outbox{wake: map[string]chan bool{}, incoming: make(chan bool, 1)}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Error generating id: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func backoff(attempts int) time.Duration {
+	d := minBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// load reads the outbox from disk.  Anything queued for a notifier
+// that's no longer configured goes straight to the dead letters, and
+// anything the deduper was holding back is queued to go out now.
+func (o *outbox) load(fn string, notifiers []notifier) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.fn = fn
+	defer func() {
+		o.qmu.Lock()
+		o.queue = append(o.Held, o.queue...)
+		o.qmu.Unlock()
+		o.Held = nil
+		o.signal()
+	}()
+
+	f, err := os.Open(fn)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error opening outbox: %v", err)
+	}
+	defer f.Close()
+
+	d := json.NewDecoder(f)
+	err = d.Decode(o)
+	if err != nil {
+		log.Fatalf("Error decoding outbox: %v", err)
+	}
+
+	known := map[string]bool{}
+	for _, n := range notifiers {
+		known[n.Name] = true
+	}
+	var pending []*outboxEntry
+	for _, e := range o.Pending {
+		if known[e.Notifier] {
+			pending = append(pending, e)
+			continue
+		}
+		e.Error = "notifier no longer configured"
+		o.bury(e)
+	}
+	o.Pending = pending
+	for name := range o.Suppressed {
+		if !known[name] {
+			delete(o.Suppressed, name)
+		}
+	}
+
+	if len(o.Pending) > 0 {
+		log.Printf("Resuming delivery of %v queued notifications",
+			len(o.Pending))
+	}
+}
+
+func (o *outbox) signal() {
+	select {
+	case o.incoming <- true:
+	default:
+	}
+}
+
+// post queues a notification for routing.  It only touches memory;
+// the notify goroutine writes it to disk once it's routed.
+func (o *outbox) post(note notification) {
+	o.qmu.Lock()
+	o.queue = append(o.queue, note)
+	o.qmu.Unlock()
+	o.signal()
+}
+
+// takeIncoming returns everything posted since it was last called,
+// oldest first.
+func (o *outbox) takeIncoming() []notification {
+	o.qmu.Lock()
+	defer o.qmu.Unlock()
+
+	rv := o.queue
+	o.queue = nil
+	return rv
+}
+
+// queued returns how many posted notifications are waiting to be
+// routed.
+func (o *outbox) queued() int {
+	o.qmu.Lock()
+	defer o.qmu.Unlock()
+	return len(o.queue)
+}
+
+// hold records what the deduper is holding back, so it isn't lost if
+// we go down before the window closes.
+func (o *outbox) hold(notes []notification) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if reflect.DeepEqual(notes, o.Held) {
+		return
+	}
+	o.Held = notes
+	o.persist()
+}
+
+// Must be called with the lock held.
+func (o *outbox) bury(e *outboxEntry) {
+	o.Dead = append(o.Dead, e)
+	if len(o.Dead) > maxDead {
+		o.Dead = o.Dead[len(o.Dead)-maxDead:]
+	}
+}
+
+// Must be called with the lock held.
+func (o *outbox) persist() {
+	if o.fn != "" {
+		persistState(o.fn, o)
+	}
+}
+
+// Must be called with the lock held.
+func (o *outbox) wakeup(name string) {
+	select {
+	case o.wakech(name) <- true:
+	default:
+	}
+}
+
+// Must be called with the lock held.
+func (o *outbox) wakech(name string) chan bool {
+	ch, ok := o.wake[name]
+	if !ok {
+		ch = make(chan bool, 1)
+		o.wake[name] = ch
+	}
+	return ch
+}
+
+func (o *outbox) add(name string, note notification) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	o.Pending = append(o.Pending, &outboxEntry{
+		ID:       newID(),
+		Notifier: name,
+		Note:     note,
+		Created:  now,
+		Next:     now,
+	})
+	o.track(note.ID, name, statusQueued, 0, nil)
+	o.persist()
+	o.wakeup(name)
+}
+
+func (o *outbox) suppress(name string, note notification) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.Suppressed == nil {
+		o.Suppressed = map[string][]notification{}
+	}
+	o.Suppressed[name] = append(o.Suppressed[name], note)
+	o.track(note.ID, name, statusSuppressed, 0, nil)
+	o.persist()
+}
+
+func (o *outbox) takeSuppressed(name string) []notification {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	rv := o.Suppressed[name]
+	if len(rv) > 0 {
+		delete(o.Suppressed, name)
+		o.persist()
+	}
+	return rv
+}
+
+// next returns the oldest entry for the given notifier that's ready
+// to send.  If none are ready, it returns how long to wait before
+// asking again.
+func (o *outbox) next(name string) (*outboxEntry, time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	wait := time.Hour
+	for _, e := range o.Pending {
+		if e.Notifier != name {
+			continue
+		}
+		if !e.Next.After(now) {
+			return e, 0
+		}
+		if d := e.Next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	return nil, wait
+}
+
+func (o *outbox) remove(e *outboxEntry) {
+	for i, x := range o.Pending {
+		if x == e {
+			o.Pending = append(o.Pending[:i], o.Pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// complete records the result of a delivery attempt, scheduling a
+// retry or moving the entry to the dead letter list on failure.
+func (o *outbox) complete(e *outboxEntry, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err == nil {
+		o.remove(e)
+		o.track(e.Note.ID, e.Notifier, statusSent, e.Attempts+1, nil)
+		o.persist()
+		return
+	}
+
+	e.Attempts++
+	e.Error = err.Error()
+	if e.Attempts >= maxAttempts {
+		o.track(e.Note.ID, e.Notifier, statusDead, e.Attempts, err)
+		log.Printf("Giving up on notification %v to %v after %v attempts: %v",
+			e.ID, e.Notifier, e.Attempts, err)
+		o.remove(e)
+		o.bury(e)
+	} else {
+		o.track(e.Note.ID, e.Notifier, statusRetrying, e.Attempts, err)
+		d := backoff(e.Attempts)
+		log.Printf("Retrying notification %v to %v in %v due to %v",
+			e.ID, e.Notifier, d, err)
+		e.Next = time.Now().Add(d)
+	}
+	o.persist()
+}
+
+func (o *outbox) worker(n notifier) {
+	o.mu.Lock()
+	wake := o.wakech(n.Name)
+	o.mu.Unlock()
+
+	lim := newLimiter(n.Limit)
+
+	for {
+		e, wait := o.next(n.Name)
+		if e == nil {
+			t := time.NewTimer(wait)
+			select {
+			case <-wake:
+			case <-t.C:
+			}
+			t.Stop()
+			continue
+		}
+
+		if d := lim.reserve(time.Now()); d > 0 {
+			log.Printf("Notifier %v is over its rate limit, waiting %v", n.Name, d)
+			time.Sleep(d)
+			continue
+		}
+
+		log.Printf("Sending notification %v to %v:  %v", e.ID, n.Name, e.Note)
+		note := e.Note
+		note.Delivery = e.ID
+		err := notifyFuns[n.Driver](n, note)
+		countDelivery(n, err)
+		o.complete(e, err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		exp      time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{30, maxBackoff},
+	}
+
+	for _, test := range tests {
+		if got := backoff(test.attempts); got != test.exp {
+			t.Errorf("Expected %v after %v attempts, got %v",
+				test.exp, test.attempts, got)
+		}
+	}
+}
+
+func TestOutboxDeadLetter(t *testing.T) {
+	o := &outbox{wake: map[string]chan bool{}}
+	o.add("n", o.record(notification{Event: "e", Msg: "m"}, time.Now()))
+
+	e, _ := o.next("n")
+	if e == nil {
+		t.Fatalf("Expected an entry to be ready")
+	}
+	if e2, _ := o.next("other"); e2 != nil {
+		t.Fatalf("Expected nothing for another notifier, got %v", e2)
+	}
+
+	for i := 0; i < maxAttempts; i++ {
+		o.complete(e, errors.New("broken"))
+	}
+
+	if len(o.Pending) != 0 || len(o.Dead) != 1 {
+		t.Fatalf("Expected entry to be dead, pending=%v, dead=%v",
+			o.Pending, o.Dead)
+	}
+	if o.Dead[0].Error != "broken" {
+		t.Errorf("Expected error to be recorded, got %q", o.Dead[0].Error)
+	}
+
+	h := o.recent(10)
+	if len(h) != 1 || h[0].Deliveries["n"].Status != statusDead {
+		t.Errorf("Expected history to show a dead delivery, got %+v", h)
+	}
+}
+
+func TestOutboxLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "outbox.json")
+
+	o := &outbox{wake: map[string]chan bool{}}
+	o.load(fn, nil)
+	o.add("kept", notification{Event: "a"})
+	o.add("gone", notification{Event: "b"})
+	o.suppress("gone", notification{Event: "c"})
+	o.hold([]notification{{Event: "held"}})
+
+	o = &outbox{wake: map[string]chan bool{}, incoming: make(chan bool, 1)}
+	o.post(notification{Event: "second"})
+	o.load(fn, []notifier{{Name: "kept"}})
+
+	if len(o.Pending) != 1 || o.Pending[0].Notifier != "kept" {
+		t.Errorf("Expected only kept to be pending, got %+v", o.Pending)
+	}
+	if len(o.Dead) != 1 || o.Dead[0].Notifier != "gone" {
+		t.Errorf("Expected gone to be dead, got %+v", o.Dead)
+	}
+	if len(o.Suppressed) != 0 {
+		t.Errorf("Expected nothing suppressed, got %+v", o.Suppressed)
+	}
+
+	select {
+	case <-o.incoming:
+	default:
+		t.Errorf("Expected to be told about incoming notifications")
+	}
+	in := o.takeIncoming()
+	if len(in) != 2 || in[0].Event != "held" || in[1].Event != "second" {
+		t.Errorf("Expected held then second, got %+v", in)
+	}
+	if len(o.Held) != 0 {
+		t.Errorf("Expected held notes to be requeued, got %+v", o.Held)
+	}
+	if in := o.takeIncoming(); len(in) != 0 {
+		t.Errorf("Expected incoming to be drained, got %+v", in)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+const (
+	ownersFile = ",owners.json"
+	// Stints beyond this many per site are moved to an append-only
+	// archive next to the owners file.
+	maxOwnerships = 500
+)
+
+// An ownership is one owner's stint holding a site's gem.  Until is
+// zero for the current owner.
+type ownership struct {
+	Name    string         `json:"name"`
+	Link    string         `json:"link,omitempty"`
+	Address string         `json:"address,omitempty"`
+	Mine    bool           `json:"mine"`
+	Value   bitcoin.Amount `json:"value"`
+	Since   time.Time      `json:"since"`
+	Until   time.Time      `json:"until"`
+	// The lowest value seen during the stint, if it dropped.
+	Low bitcoin.Amount `json:"low,omitempty"`
+}
+
+func (o ownership) low() bitcoin.Amount {
+	if o.Low != 0 {
+		return o.Low
+	}
+	return o.Value
+}
+
+func (o ownership) key() string {
+	if o.Address != "" {
+		return o.Address
+	}
+	return o.Name + "\x00" + o.Link
+}
+
+func (o ownership) Held(now time.Time) time.Duration {
+	if o.Until.IsZero() {
+		return now.Sub(o.Since)
+	}
+	return o.Until.Sub(o.Since)
+}
+
+type ownerLog struct {
+	mu    sync.Mutex
+	fn    string
+	Sites map[string][]ownership `json:"sites"`
+}
+
+var owners = &ownerLog{Sites: map[string][]ownership{}}
+
+func (l *ownerLog) load(fn string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.fn = fn
+
+	f, err := os.Open(fn)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error opening owners: %v", err)
+	}
+	defer f.Close()
+
+	d := json.NewDecoder(f)
+	err = d.Decode(l)
+	if err != nil {
+		log.Fatalf("Error decoding owners: %v", err)
+	}
+	if l.Sites == nil {
+		l.Sites = map[string][]ownership{}
+	}
+}
+
+func (l *ownerLog) archiveFile() string {
+	return strings.TrimSuffix(l.fn, ".json") + "-archive.json"
+}
+
+// rotate moves a site's oldest stints into the archive once there are
+// too many.  Must be called with the lock held.
+func (l *ownerLog) rotate(site string) {
+	hist := l.Sites[site]
+	n := len(hist) - maxOwnerships
+	if n <= 0 || l.fn == "" {
+		return
+	}
+
+	f, err := os.OpenFile(l.archiveFile(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Printf("Error opening owners archive: %v", err)
+		return
+	}
+	defer f.Close()
+
+	e := json.NewEncoder(f)
+	for _, o := range hist[:n] {
+		rec := struct {
+			Site string `json:"site"`
+			ownership
+		}{site, o}
+		if err := e.Encode(rec); err != nil {
+			log.Printf("Error archiving owners: %v", err)
+			return
+		}
+	}
+	l.Sites[site] = append([]ownership{}, hist[n:]...)
+}
+
+// observe notes who owns a site, returning the new ownership if it
+// just changed hands.  The value only goes up when someone buys, so a
+// rise is a new stint even if the owner's the same.
+func (l *ownerLog) observe(site string, st State, t time.Time) (ownership, bool) {
+	o := ownership{
+		Name:    st.OwnerName,
+		Link:    st.OwnerLink,
+		Address: st.OwnerAddress,
+		Mine:    st.IsMine,
+		Value:   st.Value,
+		Since:   t,
+	}
+	if o.key() == "\x00" {
+		return o, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	hist := l.Sites[site]
+	if n := len(hist); n > 0 && hist[n-1].key() == o.key() && o.Value <= hist[n-1].low() {
+		if o.Value == hist[n-1].low() {
+			return o, false
+		}
+		hist[n-1].Low = o.Value
+		l.persist()
+		return o, false
+	}
+	if n := len(hist); n > 0 {
+		hist[n-1].Until = t
+	}
+	l.Sites[site] = append(hist, o)
+	l.rotate(site)
+	l.persist()
+	return o, true
+}
+
+// Must be called with the lock held.
+func (l *ownerLog) persist() {
+	if l.fn != "" {
+		persistState(l.fn, l)
+	}
+}
+
+func (l *ownerLog) history(site string) []ownership {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]ownership{}, l.Sites[site]...)
+}
+
+func (l *ownerLog) current(site string) (ownership, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	hist := l.Sites[site]
+	if len(hist) == 0 {
+		return ownership{}, false
+	}
+	return hist[len(hist)-1], true
+}
+
+// serveOwners serves /owners/<slug>.json, oldest owner first.
+func serveOwners(w http.ResponseWriter, req *http.Request) {
+	slug := strings.TrimPrefix(req.URL.Path, "/owners/")
+	if !strings.HasSuffix(slug, ".json") {
+		http.NotFound(w, req)
+		return
+	}
+	s := findSiteBySlug(strings.TrimSuffix(slug, ".json"))
+	if s == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	now := time.Now()
+	type heldOwnership struct {
+		ownership
+		HeldSeconds float64 `json:"held_seconds"`
+	}
+	rv := []heldOwnership{}
+	for _, o := range owners.history(s.name()) {
+		rv = append(rv, heldOwnership{o, o.Held(now).Seconds()})
+	}
+	writeJSON(w, 200, rv)
+}
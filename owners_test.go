@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+func TestOwnerLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "owners")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "owners.json")
+
+	start := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	l := &ownerLog{Sites: map[string][]ownership{}}
+	l.load(fn)
+
+	tests := []struct {
+		st      State
+		changed bool
+	}{
+		{State{Value: 100}, false},
+		{State{Value: 100, OwnerName: "bob", OwnerAddress: "1bob"}, true},
+		{State{Value: 90, OwnerName: "bob", OwnerAddress: "1bob"}, false},
+		{State{Value: 90, OwnerName: "robert", OwnerAddress: "1bob"}, false},
+		// Bob buys it again from himself.
+		{State{Value: 95, OwnerName: "robert", OwnerAddress: "1bob"}, true},
+		{State{Value: 130, OwnerName: "alice"}, true},
+		{State{Value: 140, OwnerName: "alice", OwnerLink: "x"}, true},
+	}
+	for i, test := range tests {
+		_, changed := l.observe("s", test.st, start.Add(time.Duration(i)*time.Hour))
+		if changed != test.changed {
+			t.Errorf("On %v, expected changed=%v", i, test.changed)
+		}
+	}
+
+	l = &ownerLog{}
+	l.load(fn)
+	hist := l.history("s")
+	if len(hist) != 4 {
+		t.Fatalf("Expected 4 stints, got %+v", hist)
+	}
+	if hist[0].Name != "bob" || hist[0].Held(start) != 3*time.Hour || hist[0].Low != 90 {
+		t.Errorf("Expected bob to hold for 3h down to 90, got %+v", hist[0])
+	}
+	if hist[1].Name != "robert" || hist[1].Value != 95 {
+		t.Errorf("Expected robert's re-buy at 95, got %+v", hist[1])
+	}
+	cur, ok := l.current("s")
+	if !ok || cur.Name != "alice" || !cur.Until.IsZero() {
+		t.Errorf("Expected alice to be current, got %+v", cur)
+	}
+}
+
+func TestOwnerLogRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "owners")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	l := &ownerLog{Sites: map[string][]ownership{}}
+	l.load(filepath.Join(dir, "owners.json"))
+	for i := 0; i < maxOwnerships+3; i++ {
+		st := State{Value: bitcoin.Amount(i + 1), OwnerName: fmt.Sprintf("o%d", i)}
+		l.observe("s", st, start.Add(time.Duration(i)*time.Hour))
+	}
+
+	hist := l.history("s")
+	if len(hist) != maxOwnerships || hist[0].Name != "o3" {
+		t.Errorf("Expected the latest %v stints from o3, got %v from %v",
+			maxOwnerships, len(hist), hist[0].Name)
+	}
+
+	data, err := ioutil.ReadFile(l.archiveFile())
+	if err != nil {
+		t.Fatalf("Error reading archive: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `"site":"s"`) ||
+		!strings.Contains(lines[0], `"name":"o0"`) {
+		t.Errorf("Expected o0 to o2 archived, got %v", lines)
+	}
+}
+
+func TestServeOwners(t *testing.T) {
+	oldSites, oldOwners := conf.Sites, owners
+	defer func() { conf.Sites, owners = oldSites, oldOwners }()
+	conf.Sites = []site{{ReadURL: "http://bitgems.example/bears"}}
+	owners = &ownerLog{Sites: map[string][]ownership{}}
+
+	s := &conf.Sites[0]
+	start := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	owners.observe(s.name(), State{OwnerName: "bob"}, start)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/owners/", serveOwners)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/owners/"+s.slug()+".json", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"name":"bob"`) {
+		t.Errorf("Expected bob's ownership for %v, got %v: %s", s.slug(), w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/owners/nope.json", nil))
+	if w.Code != 404 {
+		t.Errorf("Expected an unknown site to be missing, got %v", w.Code)
+	}
+}
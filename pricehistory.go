@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+const (
+	priceHistoryDir = ",history"
+	// How many of each site's latest points are kept in memory for
+	// the dashboard and competitor analysis.
+	priceTail = 5000
+)
+
+const (
+	flagMine = 1 << iota
+	flagLocked
+)
+
+// A pricePoint is a change in what a site looked like.
+type pricePoint struct {
+	Time   time.Time
+	Value  bitcoin.Amount
+	Mine   bool
+	Locked bool
+}
+
+// The on-disk form:  17 bytes per point.
+type priceRecord struct {
+	Time  int64
+	Value int64
+	Flags uint8
+}
+
+func (p pricePoint) record() priceRecord {
+	r := priceRecord{Time: p.Time.Unix(), Value: int64(p.Value)}
+	if p.Mine {
+		r.Flags |= flagMine
+	}
+	if p.Locked {
+		r.Flags |= flagLocked
+	}
+	return r
+}
+
+func (r priceRecord) point() pricePoint {
+	return pricePoint{
+		Time:   time.Unix(r.Time, 0),
+		Value:  bitcoin.Amount(r.Value),
+		Mine:   r.Flags&flagMine != 0,
+		Locked: r.Flags&flagLocked != 0,
+	}
+}
+
+func (p pricePoint) same(o pricePoint) bool {
+	return p.Value == o.Value && p.Mine == o.Mine && p.Locked == o.Locked
+}
+
+// priceStore keeps an append-only file of changes per site, and the
+// latest of them in memory.
+type priceStore struct {
+	mu   sync.Mutex
+	dir  string
+	tail map[string][]pricePoint
+}
+
+var priceHistory = &priceStore{dir: priceHistoryDir, tail: map[string][]pricePoint{}}
+
+func (p *priceStore) filename(site string) string {
+	return filepath.Join(p.dir, siteSlug(site)+".ts")
+}
+
+// Must be called with the lock held.
+func (p *priceStore) readLocked(site string) ([]pricePoint, error) {
+	f, err := os.Open(p.filename(site))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rv []pricePoint
+	r := bufio.NewReader(f)
+	for {
+		var rec priceRecord
+		err := binary.Read(r, binary.BigEndian, &rec)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// A short final record is a write cut off by a crash.
+			return rv, nil
+		}
+		if err != nil {
+			return rv, err
+		}
+		rv = append(rv, rec.point())
+	}
+}
+
+// read returns a site's entire history from disk.
+func (p *priceStore) read(site string) ([]pricePoint, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.readLocked(site)
+}
+
+// tailLocked returns the in-memory tail of a site's history, reading
+// it from disk the first time.  It may hold up to twice priceTail
+// points.  Must be called with the lock held.
+func (p *priceStore) tailLocked(site string) ([]pricePoint, error) {
+	if pts, ok := p.tail[site]; ok {
+		return pts, nil
+	}
+	pts, err := p.readLocked(site)
+	if err != nil {
+		return nil, err
+	}
+	if len(pts) > priceTail {
+		pts = append([]pricePoint{}, pts[len(pts)-priceTail:]...)
+	}
+	p.tail[site] = pts
+	return pts, nil
+}
+
+// recent returns up to the latest priceTail points of a site's
+// history without going to the disk again.
+func (p *priceStore) recent(site string) ([]pricePoint, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pts, err := p.tailLocked(site)
+	if len(pts) > priceTail {
+		pts = pts[len(pts)-priceTail:]
+	}
+	return append([]pricePoint{}, pts...), err
+}
+
+// observe records a site's state if it's changed since last time.
+func (p *priceStore) observe(site string, st State, t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pt := pricePoint{Time: t, Value: st.Value, Mine: st.IsMine, Locked: st.Locked}
+
+	pts, err := p.tailLocked(site)
+	if err != nil {
+		return err
+	}
+	if len(pts) > 0 && pts[len(pts)-1].same(pt) {
+		return nil
+	}
+
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p.filename(site), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := binary.Write(f, binary.BigEndian, pt.record()); err != nil {
+		return err
+	}
+	pts = append(pts, pt)
+	if len(pts) > 2*priceTail {
+		pts = append([]pricePoint{}, pts[len(pts)-priceTail:]...)
+	}
+	p.tail[site] = pts
+	return nil
+}
+
+func (p pricePoint) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(
+		`{"time":%q,"satoshis":%d,"btc":%v,"mine":%v,"locked":%v}`,
+		p.Time.Format(time.RFC3339), int64(p.Value), btc(p.Value),
+		p.Mine, p.Locked)), nil
+}
+
+// serveHistory serves /history/<slug>.json, optionally limited with
+// after and before.
+func serveHistory(w http.ResponseWriter, req *http.Request) {
+	slug := strings.TrimPrefix(req.URL.Path, "/history/")
+	if !strings.HasSuffix(slug, ".json") {
+		http.NotFound(w, req)
+		return
+	}
+	s := findSiteBySlug(strings.TrimSuffix(slug, ".json"))
+	if s == nil {
+		http.NotFound(w, req)
+		return
+	}
+	name := s.name()
+
+	f, err := parseTxFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	pts, err := priceHistory.read(name)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	rv := []pricePoint{}
+	for _, pt := range pts {
+		if f.inRange(pt.Time) {
+			rv = append(rv, pt)
+		}
+	}
+	writeJSON(w, 200, rv)
+}
+
+const (
+	chartWidth  = 320
+	chartHeight = 60
+	chartPoints = 500
+)
+
+// historyChart draws the recent values of a site as a step chart,
+// marking the stretches we owned it.
+func historyChart(pts []pricePoint, now time.Time) template.HTML {
+	if len(pts) > chartPoints {
+		pts = pts[len(pts)-chartPoints:]
+	}
+	if len(pts) == 0 {
+		return ""
+	}
+
+	start := pts[0].Time
+	span := now.Sub(start).Seconds()
+	if span <= 0 {
+		span = 1
+	}
+	lo, hi := pts[0].Value, pts[0].Value
+	for _, pt := range pts {
+		if pt.Value < lo {
+			lo = pt.Value
+		}
+		if pt.Value > hi {
+			hi = pt.Value
+		}
+	}
+	vspan := float64(hi - lo)
+	if vspan == 0 {
+		vspan = 1
+	}
+
+	x := func(t time.Time) float64 {
+		return t.Sub(start).Seconds() / span * chartWidth
+	}
+	y := func(v bitcoin.Amount) float64 {
+		return chartHeight - 2 - float64(v-lo)/vspan*(chartHeight-4)
+	}
+
+	var path, owned []string
+	for i, pt := range pts {
+		end := now
+		if i+1 < len(pts) {
+			end = pts[i+1].Time
+		}
+		cmd := "L"
+		if i == 0 {
+			cmd = "M"
+		}
+		path = append(path, fmt.Sprintf("%s%.1f,%.1f H%.1f", cmd, x(pt.Time), y(pt.Value), x(end)))
+		if pt.Mine {
+			owned = append(owned, fmt.Sprintf(
+				`<rect x="%.1f" y="0" width="%.1f" height="%d" fill="#e6f6e6"/>`,
+				x(pt.Time), x(end)-x(pt.Time), chartHeight))
+		}
+	}
+
+	return template.HTML(fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">%s`+
+			`<path d="%s" fill="none" stroke="#36c" stroke-width="1.5"/>`+
+			`<title>%v - %v since %s</title></svg>`,
+		chartWidth, chartHeight, strings.Join(owned, ""),
+		strings.Join(path, " "), lo, hi, start.Format("2006-01-02")))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+func TestPriceHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	states := []State{
+		{Value: 100},
+		{Value: 100},
+		{Value: 100, Locked: true},
+		{Value: 150, IsMine: true},
+		{Value: 150, IsMine: true},
+		{Value: 90},
+	}
+
+	p := &priceStore{dir: dir, tail: map[string][]pricePoint{}}
+	for i, st := range states {
+		if err := p.observe("a/b", st, start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("Error observing: %v", err)
+		}
+	}
+
+	// A fresh store should pick up where the last left off.
+	p = &priceStore{dir: dir, tail: map[string][]pricePoint{}}
+	if err := p.observe("a/b", states[len(states)-1], start.Add(time.Hour)); err != nil {
+		t.Fatalf("Error observing: %v", err)
+	}
+
+	// And a partial record at the end shouldn't hurt.
+	f, err := os.OpenFile(p.filename("a/b"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Error opening history: %v", err)
+	}
+	f.Write([]byte{1, 2, 3})
+	f.Close()
+
+	pts, err := p.read("a/b")
+	if err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	if len(pts) != 4 {
+		t.Fatalf("Expected 4 changes, got %+v", pts)
+	}
+	exp := pricePoint{Time: start.Add(3 * time.Minute), Value: 150, Mine: true}
+	if !pts[2].same(exp) || !pts[2].Time.Equal(exp.Time) {
+		t.Errorf("Expected %+v, got %+v", exp, pts[2])
+	}
+
+	data, err := json.Marshal(pts[1])
+	if err != nil {
+		t.Fatalf("Error marshaling: %v", err)
+	}
+	if string(data) != `{"time":"2013-05-01T00:02:00Z","satoshis":100,"btc":1e-06,"mine":false,"locked":true}` {
+		t.Errorf("Unexpected JSON: %s", data)
+	}
+
+	svg := string(historyChart(pts, start.Add(2*time.Hour)))
+	if !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, "<rect") {
+		t.Errorf("Expected a chart with an owned stretch, got %v", svg)
+	}
+	if historyChart(nil, start) != "" {
+		t.Errorf("Expected no chart without history")
+	}
+}
+
+func TestServeHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldSites, oldHistory := conf.Sites, priceHistory
+	defer func() { conf.Sites, priceHistory = oldSites, oldHistory }()
+	conf.Sites = []site{{ReadURL: "http://bitgems.example/bears"}}
+	priceHistory = &priceStore{dir: dir, tail: map[string][]pricePoint{}}
+
+	s := &conf.Sites[0]
+	start := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	priceHistory.observe(s.name(), State{Value: 100}, start)
+	priceHistory.observe(s.name(), State{Value: 200}, start.Add(time.Hour))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/history/", serveHistory)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/history/"+s.slug()+".json", nil))
+	var pts []json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &pts); w.Code != 200 || err != nil || len(pts) != 2 {
+		t.Fatalf("Expected two points for %v, got %v: %s", s.slug(), w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/history/nope.json", nil))
+	if w.Code != 404 {
+		t.Errorf("Expected an unknown site to be missing, got %v", w.Code)
+	}
+}
+
+func TestPriceHistoryTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	n := 2*priceTail + 5
+	p := &priceStore{dir: dir, tail: map[string][]pricePoint{}}
+	for i := 0; i < n; i++ {
+		st := State{Value: bitcoin.Amount(i)}
+		if err := p.observe("a", st, start.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("Error observing: %v", err)
+		}
+	}
+	if l := len(p.tail["a"]); l > 2*priceTail {
+		t.Errorf("Expected the tail to be bounded, got %v points", l)
+	}
+
+	check := func(p *priceStore) {
+		pts, err := p.recent("a")
+		if err != nil {
+			t.Fatalf("Error reading recent history: %v", err)
+		}
+		if len(pts) != priceTail || pts[0].Value != bitcoin.Amount(n-priceTail) ||
+			pts[len(pts)-1].Value != bitcoin.Amount(n-1) {
+			t.Errorf("Expected the latest %v points, got %v from %v to %v",
+				priceTail, len(pts), pts[0].Value, pts[len(pts)-1].Value)
+		}
+	}
+	check(p)
+	check(&priceStore{dir: dir, tail: map[string][]pricePoint{}})
+
+	if all, err := p.read("a"); err != nil || len(all) != n {
+		t.Errorf("Expected all %v points on disk, got %v/%v", n, len(all), err)
+	}
+}
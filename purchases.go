@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	defaultConfirmations = 6
+	defaultStuckAfter    = 2 * time.Hour
+)
+
+// purchaseConf says how closely we follow our own purchases once
+// they're sent.
+type purchaseConf struct {
+	Confirmations int      `json:"confirmations"`
+	StuckAfter    duration `json:"stuck_after"`
+}
+
+func (c purchaseConf) depth() int {
+	if c.Confirmations <= 0 {
+		return defaultConfirmations
+	}
+	return c.Confirmations
+}
+
+func (c purchaseConf) stuckAfter() time.Duration {
+	if c.StuckAfter <= 0 {
+		return defaultStuckAfter
+	}
+	return time.Duration(c.StuckAfter)
+}
+
+// watchPurchases resumes watching purchases that hadn't settled
+// when we last stopped.
+func watchPurchases() {
+	for _, t := range trades.unsettled() {
+		txWatch.watchPurchase(t.TXID, t.Site, t.Time, conf.Purchases.depth())
+	}
+}
+
+// settlePurchase records how one of our purchases turned out.
+func settlePurchase(t watchedTx) {
+	trades.settle(t.TXID, t.Outcome, t.Done)
+
+	site := ""
+	if len(t.Sites) > 0 {
+		site = t.Sites[0]
+	}
+	note := notification{Site: site}
+	switch t.Outcome {
+	case "confirmed":
+		note.Event = "Purchase confirmed on " + site
+		note.Msg = fmt.Sprintf("%v reached %v confirmations after %v",
+			t.TXID, t.Confirmations, t.Done.Sub(t.Added).Truncate(time.Second))
+		note.Severity = sevInfo
+	default:
+		note.Event = "Purchase " + t.Outcome + " on " + site
+		note.Msg = fmt.Sprintf("Our payment %v for %v was %v", t.TXID, site, t.Outcome)
+		if t.Error != "" {
+			note.Msg += ": " + t.Error
+		}
+		note.Severity = sevCritical
+	}
+	log.Printf("%v", note.Msg)
+	postNotification(note)
+}
+
+func alertStuck(t watchedTx) {
+	site := ""
+	if len(t.Sites) > 0 {
+		site = t.Sites[0]
+	}
+	postNotification(notification{
+		Event: "Purchase unconfirmed on " + site,
+		Msg: fmt.Sprintf("Our payment %v for %v has been unconfirmed for %v",
+			t.TXID, site, t.LastPoll.Sub(t.Added).Truncate(time.Second)),
+		Severity: sevWarn,
+		Site:     site,
+	})
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+// A query is a list of terms that must all match.  Terms look like
+//
+//	word            account or comment contains word
+//	"two words"     account or comment contains the phrase
+//	field:value     account, comment, txid, site or dir matches
+//	amount:>0.1     amount (ignoring direction) compares to 0.1
+//	amount:0.1..0.5 amount is within the range, inclusive
+//	-term           term does not match
+//
+// Text matches are case insensitive substring matches, except for dir
+// which must be in or out.  Numeric fields are amount, fee and conf.
+// A word with a colon that doesn't start with a field name, such as a
+// URL, is just a word.
+type query []queryTerm
+
+type queryTerm struct {
+	negate bool
+	match  func(walletTx) bool
+}
+
+func (q query) matches(t walletTx) bool {
+	for _, term := range q {
+		if term.match(t) == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+type token struct {
+	s      string
+	quoted bool // began with a quote, so is plain text
+}
+
+// tokenize splits a query on whitespace, honoring double quotes.
+func tokenize(s string) ([]token, error) {
+	var rv []token
+	var cur token
+	inToken, inQuote := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			if !inToken {
+				cur.quoted = true
+			}
+			inQuote = !inQuote
+			inToken = true
+		case !inQuote && (r == ' ' || r == '\t' || r == '\n'):
+			if inToken {
+				rv = append(rv, cur)
+				cur, inToken = token{}, false
+			}
+		default:
+			cur.s += string(r)
+			inToken = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inToken {
+		rv = append(rv, cur)
+	}
+	return rv, nil
+}
+
+func abs(a bitcoin.Amount) bitcoin.Amount {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func textMatch(get func(walletTx) string, v string) func(walletTx) bool {
+	v = strings.ToLower(v)
+	return func(t walletTx) bool {
+		return strings.Contains(strings.ToLower(get(t)), v)
+	}
+}
+
+// numMatch builds a comparison from things like ">=5", "<3", "=2",
+// "1..4" or just "2".
+func numMatch(get func(walletTx) int64, v string,
+	parse func(string) (int64, error)) (func(walletTx) bool, error) {
+
+	if i := strings.Index(v, ".."); i >= 0 {
+		lo, err := parse(v[:i])
+		if err != nil {
+			return nil, err
+		}
+		hi, err := parse(v[i+2:])
+		if err != nil {
+			return nil, err
+		}
+		return func(t walletTx) bool {
+			n := get(t)
+			return n >= lo && n <= hi
+		}, nil
+	}
+
+	ops := []struct {
+		op  string
+		cmp func(a, b int64) bool
+	}{
+		{">=", func(a, b int64) bool { return a >= b }},
+		{"<=", func(a, b int64) bool { return a <= b }},
+		{">", func(a, b int64) bool { return a > b }},
+		{"<", func(a, b int64) bool { return a < b }},
+		{"=", func(a, b int64) bool { return a == b }},
+		{"", func(a, b int64) bool { return a == b }},
+	}
+	for _, o := range ops {
+		if !strings.HasPrefix(v, o.op) {
+			continue
+		}
+		n, err := parse(v[len(o.op):])
+		if err != nil {
+			return nil, err
+		}
+		cmp := o.cmp
+		return func(t walletTx) bool { return cmp(get(t), n) }, nil
+	}
+	panic("unreachable")
+}
+
+func parseAmount(s string) (int64, error) {
+	a, err := bitcoin.AmountFromBitcoinsString(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	return int64(a), nil
+}
+
+func parseCount(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
+
+var textFields = map[string]func(walletTx) string{
+	"account": func(t walletTx) string { return t.Account },
+	"acct":    func(t walletTx) string { return t.Account },
+	"comment": func(t walletTx) string { return t.Comment },
+	"txid":    func(t walletTx) string { return t.TXID },
+	"site":    func(t walletTx) string { return t.Site },
+}
+
+var numFields = map[string]struct {
+	get   func(walletTx) int64
+	parse func(string) (int64, error)
+}{
+	"amount": {func(t walletTx) int64 { return int64(abs(t.Amount)) }, parseAmount},
+	"fee":    {func(t walletTx) int64 { return int64(abs(t.Fee)) }, parseAmount},
+	"conf":   {func(t walletTx) int64 { return int64(t.Confirmations) }, parseCount},
+}
+
+func parseTerm(t token) (queryTerm, error) {
+	var term queryTerm
+	tok := t.s
+	if !t.quoted && len(tok) > 1 && tok[0] == '-' {
+		term.negate = true
+		tok = tok[1:]
+	}
+
+	// Anything that isn't a known field:value is matched as it is,
+	// so pasted URLs and the like still work.
+	i := strings.Index(tok, ":")
+	field := ""
+	if i >= 0 && !t.quoted {
+		field = strings.ToLower(tok[:i])
+	}
+	if field == "confirmations" {
+		field = "conf"
+	}
+	_, isText := textFields[field]
+	_, isNum := numFields[field]
+	if field != "dir" && !isText && !isNum {
+		acct, comment := textMatch(textFields["account"], tok),
+			textMatch(textFields["comment"], tok)
+		term.match = func(t walletTx) bool { return acct(t) || comment(t) }
+		return term, nil
+	}
+
+	v := tok[i+1:]
+	if v == "" {
+		return term, fmt.Errorf("missing value for %v", field)
+	}
+
+	if field == "dir" {
+		if v != "in" && v != "out" {
+			return term, fmt.Errorf("invalid dir %q", v)
+		}
+		term.match = func(t walletTx) bool { return t.Dir == v }
+		return term, nil
+	}
+	if get, ok := textFields[field]; ok {
+		term.match = textMatch(get, v)
+		return term, nil
+	}
+	nf := numFields[field]
+	var err error
+	term.match, err = numMatch(nf.get, v, nf.parse)
+	return term, err
+}
+
+func parseQuery(s string) (query, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	var rv query
+	for _, tok := range toks {
+		term, err := parseTerm(tok)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, term)
+	}
+	return rv, nil
+}
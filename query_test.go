@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func TestQuery(t *testing.T) {
+	tx := walletTx{
+		Account:       "Gems",
+		Dir:           "out",
+		Comment:       "bought the bears http://bears.example/buy",
+		Confirmations: 6,
+		Amount:        -73790000,
+		Fee:           -10000,
+		TXID:          "abc123",
+		Site:          "bears",
+	}
+
+	tests := []struct {
+		q   string
+		exp bool
+	}{
+		{"", true},
+		{"gems", true},
+		{"bears", true},
+		{"goldbar", false},
+		{`"the bears"`, true},
+		{`"bears the"`, false},
+		{`comment:"the bears"`, true},
+		{"-bears", false},
+		{"-goldbar", true},
+		{"dir:out", true},
+		{"dir:in", false},
+		{"site:bear", true},
+		{"-site:bears", false},
+		{"txid:ABC", true},
+		{"amount:>0.5", true},
+		{"amount:>=0.7379", true},
+		{"amount:<0.7379", false},
+		{"amount:0.7..0.8", true},
+		{"amount:0.8..0.9", false},
+		{"amount:0.7379", true},
+		{"fee:<0.001", true},
+		{"conf:>=6", true},
+		{"confirmations:>6", false},
+		{"gems dir:out amount:>0.1", true},
+		{"gems dir:in", false},
+		// Unknown fields are plain text, as before there were fields.
+		{"http://bears.example/buy", true},
+		{"http://goldbar.example/", false},
+		{"-http://bears.example/buy", false},
+		{"nosuch:field", false},
+	}
+
+	for _, test := range tests {
+		q, err := parseQuery(test.q)
+		if err != nil {
+			t.Errorf("Error parsing %q: %v", test.q, err)
+			continue
+		}
+		if got := q.matches(tx); got != test.exp {
+			t.Errorf("Expected %q to match=%v", test.q, test.exp)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []string{
+		`"unterminated`,
+		"dir:sideways",
+		"amount:>lots",
+		"amount:1..",
+		"conf:many",
+		"site:",
+	}
+
+	for _, test := range tests {
+		if _, err := parseQuery(test); err == nil {
+			t.Errorf("Expected error parsing %q", test)
+		}
+	}
+}
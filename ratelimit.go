@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A deduper collapses notifications of the same event for the same
+// site seen within a window.  The first is passed through right
+// away, and any repeats are summarized with a count (and the latest
+// message) once the window closes.
+type deduper struct {
+	window time.Duration
+	seen   map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	note    notification
+	first   time.Time
+	repeats int
+}
+
+func newDeduper(window time.Duration) *deduper {
+	return &deduper{window: window, seen: map[string]*dedupEntry{}}
+}
+
+func dedupKey(note notification) string {
+	return note.Event + "\x00" + note.Site
+}
+
+// observe records a notification, returning what should be sent now.
+func (d *deduper) observe(note notification, now time.Time) []notification {
+	if d.window <= 0 {
+		return []notification{note}
+	}
+	k := dedupKey(note)
+	e, ok := d.seen[k]
+	if ok && now.Sub(e.first) < d.window {
+		e.note = note
+		e.repeats++
+		return nil
+	}
+	var rv []notification
+	if ok {
+		// The window closed before flush got to it.
+		rv = append(rv, d.summary(e)...)
+	}
+	d.seen[k] = &dedupEntry{note: note, first: now}
+	return append(rv, note)
+}
+
+func (d *deduper) summary(e *dedupEntry) []notification {
+	if e.repeats == 0 {
+		return nil
+	}
+	note := e.note
+	note.Msg = fmt.Sprintf("%s (repeated %d more times in %v)",
+		note.Msg, e.repeats, d.window)
+	return []notification{note}
+}
+
+// held returns the summaries that would be sent for every window
+// that's still open.
+func (d *deduper) held() []notification {
+	var keys []string
+	for k := range d.seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var rv []notification
+	for _, k := range keys {
+		rv = append(rv, d.summary(d.seen[k])...)
+	}
+	return rv
+}
+
+// flush returns summaries of repeated notifications whose window has
+// closed.
+func (d *deduper) flush(now time.Time) []notification {
+	var rv []notification
+	for k, e := range d.seen {
+		if now.Sub(e.first) < d.window {
+			continue
+		}
+		rv = append(rv, d.summary(e)...)
+		delete(d.seen, k)
+	}
+	return rv
+}
+
+// rateLimit caps a notifier at Max messages per Interval.
+type rateLimit struct {
+	Max      int
+	Interval duration
+}
+
+type limiter struct {
+	max      int
+	interval time.Duration
+	sent     []time.Time
+}
+
+func newLimiter(rl *rateLimit) *limiter {
+	if rl == nil || rl.Max <= 0 || rl.Interval <= 0 {
+		return &limiter{}
+	}
+	return &limiter{max: rl.Max, interval: time.Duration(rl.Interval)}
+}
+
+// reserve claims a slot to send at the given time, or returns how
+// long to wait until one is available.
+func (l *limiter) reserve(now time.Time) time.Duration {
+	if l.max == 0 {
+		return 0
+	}
+	for len(l.sent) > 0 && now.Sub(l.sent[0]) >= l.interval {
+		l.sent = l.sent[1:]
+	}
+	if len(l.sent) >= l.max {
+		return l.sent[0].Add(l.interval).Sub(now)
+	}
+	l.sent = append(l.sent, now)
+	return 0
+}
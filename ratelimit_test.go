@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeduper(t *testing.T) {
+	start := time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)
+	d := newDeduper(time.Minute)
+	note := func(msg string) notification {
+		return notification{Event: "e", Site: "s", Msg: msg}
+	}
+
+	if got := d.observe(note("blocked 1"), start); len(got) != 1 {
+		t.Fatalf("Expected first notification to go through, got %v", got)
+	}
+	for i := 2; i <= 4; i++ {
+		msg := fmt.Sprintf("blocked %v", i)
+		if got := d.observe(note(msg), start.Add(time.Duration(i)*time.Second)); len(got) != 0 {
+			t.Fatalf("Expected repeat %v to be collapsed, got %v", i, got)
+		}
+	}
+	if got := d.observe(notification{Event: "e", Site: "other"}, start); len(got) != 1 {
+		t.Fatalf("Expected another site to go through, got %v", got)
+	}
+
+	if got := d.flush(start.Add(30 * time.Second)); len(got) != 0 {
+		t.Fatalf("Expected nothing flushed mid-window, got %v", got)
+	}
+	if held := d.held(); len(held) != 1 || !strings.Contains(held[0].Msg, "repeated 3 more times") {
+		t.Fatalf("Expected the repeats to be held, got %v", held)
+	}
+	got := d.flush(start.Add(time.Minute))
+	if len(got) != 1 || got[0].Msg != "blocked 4 (repeated 3 more times in 1m0s)" {
+		t.Fatalf("Expected one summary of 3 repeats, got %v", got)
+	}
+	if held := d.held(); len(held) != 0 {
+		t.Fatalf("Expected nothing held after the flush, got %v", held)
+	}
+
+	if got := d.observe(note("blocked"), start.Add(2*time.Minute)); len(got) != 1 {
+		t.Fatalf("Expected notification after the window to go through, got %v", got)
+	}
+}
+
+func TestDeduperWindowBoundary(t *testing.T) {
+	start := time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)
+	d := newDeduper(time.Minute)
+	note := notification{Event: "e", Site: "s", Msg: "m"}
+
+	d.observe(note, start)
+	d.observe(note, start.Add(time.Second))
+	d.observe(note, start.Add(2*time.Second))
+
+	// The window closes and another arrives before the next flush.
+	got := d.observe(note, start.Add(time.Minute+time.Millisecond))
+	if len(got) != 2 || !strings.Contains(got[0].Msg, "repeated 2 more times") || got[1].Msg != "m" {
+		t.Fatalf("Expected the summary then the new notification, got %v", got)
+	}
+	if got := d.flush(start.Add(2 * time.Minute)); len(got) != 0 {
+		t.Fatalf("Expected nothing left to flush, got %v", got)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	start := time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)
+	l := newLimiter(&rateLimit{Max: 2, Interval: duration(time.Minute)})
+
+	if l.reserve(start) != 0 || l.reserve(start.Add(time.Second)) != 0 {
+		t.Fatalf("Expected first two sends to be allowed")
+	}
+	if d := l.reserve(start.Add(10 * time.Second)); d != 50*time.Second {
+		t.Fatalf("Expected to wait 50s, got %v", d)
+	}
+	if d := l.reserve(start.Add(time.Minute)); d != 0 {
+		t.Fatalf("Expected a slot after the interval, got %v", d)
+	}
+
+	if d := newLimiter(nil).reserve(start); d != 0 {
+		t.Fatalf("Expected no limit without config, got %v", d)
+	}
+}
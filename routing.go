@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	sevInfo     = "info"
+	sevWarn     = "warn"
+	sevCritical = "critical"
+)
+
+var severities = map[string]int{
+	"":          0,
+	sevInfo:     0,
+	sevWarn:     1,
+	sevCritical: 2,
+}
+
+// quietHours describe a daily window (in local time, possibly
+// wrapping midnight) during which a notifier only receives critical
+// notifications.  With Digest set, everything else is held and sent
+// as a single summary once the window is over.
+type quietHours struct {
+	Start  string
+	End    string
+	Digest bool
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (q *quietHours) validate() error {
+	if _, err := parseClock(q.Start); err != nil {
+		return err
+	}
+	_, err := parseClock(q.End)
+	return err
+}
+
+func (q *quietHours) contains(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+	start, err := parseClock(q.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(q.End)
+	if err != nil {
+		return false
+	}
+	tod := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute
+	if start <= end {
+		return tod >= start && tod < end
+	}
+	return tod >= start || tod < end
+}
+
+// globMatch matches s against a pattern where * matches any run of
+// characters (including /) and ? matches any single character.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if globMatch(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n notifier) validate() error {
+	if _, ok := severities[n.Severity]; !ok {
+		return fmt.Errorf("unknown severity %q", n.Severity)
+	}
+	if n.Quiet != nil {
+		return n.Quiet.validate()
+	}
+	return nil
+}
+
+// wants reports whether the notifier subscribes to the given
+// notification by event, severity and site.
+func (n notifier) wants(note notification) bool {
+	events := n.Events
+	if n.Event != "" {
+		events = append([]string{n.Event}, events...)
+	}
+	if len(events) > 0 && !matchAny(events, note.Event) {
+		return false
+	}
+	if severities[note.Severity] < severities[n.Severity] {
+		return false
+	}
+	if len(n.Sites) > 0 && !matchAny(n.Sites, note.Site) {
+		return false
+	}
+	return true
+}
+
+func route(notifiers []notifier, note notification, now time.Time) {
+	note = notifyOutbox.record(note, now)
+	events.publish(evNotification, note.Site, note)
+	for _, n := range notifiers {
+		if n.Disabled || !n.wants(note) {
+			continue
+		}
+		if note.Severity != sevCritical && n.Quiet.contains(now) {
+			if n.Quiet.Digest {
+				notifyOutbox.suppress(n.Name, note)
+			} else {
+				log.Printf("Dropping notification to %v during quiet hours: %v",
+					n.Name, note)
+				notifyOutbox.setStatus(note.ID, n.Name, statusDropped, 0, nil)
+			}
+			continue
+		}
+		notifyOutbox.add(n.Name, note)
+	}
+}
+
+func digestOf(notes []notification) notification {
+	lines := make([]string, 0, len(notes))
+	for _, note := range notes {
+		lines = append(lines, note.Msg)
+	}
+	return notification{
+		Event: "Digest",
+		Msg: fmt.Sprintf("%d notifications during quiet hours:\n%s",
+			len(notes), strings.Join(lines, "\n")),
+		Severity: sevInfo,
+	}
+}
+
+// sendDigests delivers whatever was held back for notifiers whose
+// quiet hours have ended.
+func sendDigests(notifiers []notifier, now time.Time) {
+	for _, n := range notifiers {
+		if n.Disabled || n.Quiet.contains(now) {
+			continue
+		}
+		notes := notifyOutbox.takeSuppressed(n.Name)
+		if len(notes) > 0 {
+			notifyOutbox.add(n.Name, notifyOutbox.record(digestOf(notes), now))
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		exp        bool
+	}{
+		{"", "", true},
+		{"Sold *", "Sold http://example.com/gem/", true},
+		{"Sold *", "Purchased from http://example.com/", false},
+		{"*example*", "Purchased from http://example.com/", true},
+		{"Sold ?", "Sold x", true},
+		{"Sold ?", "Sold xy", false},
+		{"exact", "exact", true},
+		{"exact", "exactly", false},
+	}
+
+	for _, test := range tests {
+		if got := globMatch(test.pattern, test.s); got != test.exp {
+			t.Errorf("Expected %q ~ %q = %v", test.pattern, test.s, test.exp)
+		}
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	at := func(h, m int) time.Time {
+		return time.Date(2013, 5, 1, h, m, 0, 0, time.Local)
+	}
+	overnight := &quietHours{Start: "22:00", End: "07:30"}
+	daytime := &quietHours{Start: "09:00", End: "17:00"}
+
+	tests := []struct {
+		q   *quietHours
+		t   time.Time
+		exp bool
+	}{
+		{nil, at(23, 0), false},
+		{overnight, at(23, 0), true},
+		{overnight, at(3, 0), true},
+		{overnight, at(7, 30), false},
+		{overnight, at(12, 0), false},
+		{daytime, at(12, 0), true},
+		{daytime, at(8, 59), false},
+		{daytime, at(17, 0), false},
+	}
+
+	for _, test := range tests {
+		if got := test.q.contains(test.t); got != test.exp {
+			t.Errorf("Expected %v in %+v = %v", test.t, test.q, test.exp)
+		}
+	}
+}
+
+func TestNotifierWants(t *testing.T) {
+	n := notifier{
+		Events:   []string{"Sold *", "Purchased *"},
+		Severity: sevWarn,
+		Sites:    []string{"bears"},
+	}
+
+	tests := []struct {
+		note notification
+		exp  bool
+	}{
+		{notification{Event: "Sold x", Severity: sevWarn, Site: "bears"}, true},
+		{notification{Event: "Sold x", Severity: sevCritical, Site: "bears"}, true},
+		{notification{Event: "Sold x", Severity: sevInfo, Site: "bears"}, false},
+		{notification{Event: "Sold x", Severity: sevWarn, Site: "goldbar"}, false},
+		{notification{Event: "Digest", Severity: sevWarn, Site: "bears"}, false},
+	}
+
+	for _, test := range tests {
+		if got := n.wants(test.note); got != test.exp {
+			t.Errorf("Expected wants(%+v) = %v", test.note, test.exp)
+		}
+	}
+
+	if !(notifier{}).wants(notification{Event: "anything"}) {
+		t.Errorf("Expected an unfiltered notifier to want everything")
+	}
+}
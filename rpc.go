@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const rpcTimeout = 30 * time.Second
+
+// rpcError is an error reported by bitcoind itself.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("bitcoind error %v: %v", e.Code, e.Message)
+}
+
+// bitcoind's code for a txid it knows nothing about.
+const rpcInvalidTxid = -5
+
+var rpcClient = &http.Client{Timeout: rpcTimeout}
+
+// callBitcoind makes a JSON-RPC call for the few methods our bitcoin
+// client doesn't have.
+func callBitcoind(method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "1.0",
+		"id":      method,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", conf.Bitcoin, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(conf.BitcoinUser, conf.BitcoinPass)
+
+	done := timeRPC(method)
+	defer done()
+	res, err := rpcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var rv struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&rv); err != nil {
+		return fmt.Errorf("Error decoding %v response (%v): %v", method, res.Status, err)
+	}
+	if rv.Error != nil {
+		return rv.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(rv.Result, result)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCallBitcoind(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if u, p, ok := req.BasicAuth(); !ok || u != "u" || p != "p" {
+			t.Errorf("Expected auth, got %v/%v", u, p)
+		}
+		var r struct {
+			Method string
+			Params []interface{}
+		}
+		if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+			t.Fatalf("Error decoding request: %v", err)
+		}
+		switch r.Method {
+		case "gettransaction":
+			w.Write([]byte(`{"result":{"confirmations":3},"error":null,"id":"x"}`))
+		default:
+			w.WriteHeader(500)
+			w.Write([]byte(`{"result":null,"error":{"code":-5,"message":"nope"},"id":"x"}`))
+		}
+	}))
+	defer ts.Close()
+
+	conf.Bitcoin, conf.BitcoinUser, conf.BitcoinPass = ts.URL, "u", "p"
+	defer func() { conf.Bitcoin, conf.BitcoinUser, conf.BitcoinPass = "", "", "" }()
+
+	var tx struct{ Confirmations int }
+	if err := callBitcoind("gettransaction", &tx, "abc"); err != nil {
+		t.Fatalf("Error calling: %v", err)
+	}
+	if tx.Confirmations != 3 {
+		t.Errorf("Expected 3 confirmations, got %+v", tx)
+	}
+
+	err := callBitcoind("other", nil)
+	if e, ok := err.(*rpcError); !ok || e.Code != rpcInvalidTxid {
+		t.Errorf("Expected an rpc error, got %v", err)
+	}
+}
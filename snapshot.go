@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// snapshotConf configures periodic copies of the exports and gains
+// report written to a local directory.
+type snapshotConf struct {
+	Dir      string
+	Schedule string // cron format, e.g. "0 0 * * *"
+	Retain   int    // how many of each to keep, 0 for all
+	Formats  []string
+}
+
+type snapshotter struct {
+	prefix, ext string
+	write       func(w io.Writer) error
+}
+
+func streamAll(write func(io.Writer, *txStream) error) func(io.Writer) error {
+	return func(w io.Writer) error {
+		s, err := newTxStream(txFilter{})
+		if err != nil {
+			return err
+		}
+		return write(w, s)
+	}
+}
+
+var snapshotters = map[string]snapshotter{
+	"csv":    {"transactions", ".csv", streamAll(writeCSV)},
+	"json":   {"transactions", ".json", streamAll(writeJSONArray)},
+	"ndjson": {"transactions", ".ndjson", streamAll(writeNDJSON)},
+	"gains": {"gains", ".csv", func(w io.Writer) error {
+		gains, err := gainsReport(txFilter{})
+		if err != nil {
+			return err
+		}
+		var rates rateTable
+		if conf.RatesFile != "" {
+			rates, err = loadRates(conf.RatesFile)
+			if err != nil {
+				return err
+			}
+		}
+		return writeGains(w, gains, rates)
+	}},
+}
+
+var defaultSnapshotFormats = []string{"csv", "json", "gains"}
+
+func (c *snapshotConf) validate() error {
+	if c.Dir == "" {
+		return fmt.Errorf("no snapshot directory")
+	}
+	if _, err := parseCron(c.Schedule); err != nil {
+		return err
+	}
+	for _, f := range c.Formats {
+		if _, ok := snapshotters[f]; !ok {
+			return fmt.Errorf("unknown snapshot format %q", f)
+		}
+	}
+	return nil
+}
+
+// prune removes all but the newest n snapshots with the given prefix
+// and extension.  The timestamps in the names sort chronologically.
+func prune(dir, prefix, ext string, n int) {
+	if n <= 0 {
+		return
+	}
+	names, err := filepath.Glob(filepath.Join(dir, prefix+"-*"+ext))
+	if err != nil {
+		log.Printf("Error listing snapshots: %v", err)
+		return
+	}
+	sort.Strings(names)
+	for len(names) > n {
+		if err := os.Remove(names[0]); err != nil {
+			log.Printf("Error removing old snapshot: %v", err)
+		}
+		names = names[1:]
+	}
+}
+
+func (c *snapshotConf) snapshot(t time.Time) {
+	formats := c.Formats
+	if len(formats) == 0 {
+		formats = defaultSnapshotFormats
+	}
+
+	for _, f := range formats {
+		s := snapshotters[f]
+		fn := filepath.Join(c.Dir,
+			s.prefix+"-"+t.Format("20060102T1504")+s.ext)
+		if err := writeAtomically(fn, s.write); err != nil {
+			log.Printf("Error writing snapshot %v: %v", fn, err)
+			continue
+		}
+		log.Printf("Wrote snapshot %v", fn)
+		prune(c.Dir, s.prefix, s.ext, c.Retain)
+	}
+}
+
+func snapshots(c *snapshotConf) {
+	sched, err := parseCron(c.Schedule)
+	if err != nil {
+		log.Fatalf("Invalid snapshot schedule: %v", err)
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		log.Fatalf("Error creating snapshot directory: %v", err)
+	}
+
+	for {
+		next := sched.next(time.Now())
+		if next.IsZero() {
+			log.Printf("Snapshot schedule %q never runs", c.Schedule)
+			return
+		}
+		time.Sleep(next.Sub(time.Now()))
+		c.snapshot(next)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+func TestSnapshot(t *testing.T) {
+	defer fakeWallet(t, map[string][]bitcoin.Transaction{
+		"a": fakeTxns("a", 3, 0, time.Minute),
+	})()
+
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &snapshotConf{Dir: dir, Schedule: "0 0 * * *", Retain: 2}
+	if err := c.validate(); err != nil {
+		t.Fatalf("Invalid config: %v", err)
+	}
+
+	start := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		c.snapshot(start.AddDate(0, 0, i))
+	}
+
+	exp := []string{
+		"gains-20130502T0000.csv",
+		"gains-20130503T0000.csv",
+		"transactions-20130502T0000.csv",
+		"transactions-20130502T0000.json",
+		"transactions-20130503T0000.csv",
+		"transactions-20130503T0000.json",
+	}
+	got, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatalf("Error listing snapshots: %v", err)
+	}
+	if len(got) != len(exp) {
+		t.Fatalf("Expected %v, got %v", exp, got)
+	}
+	for i := range exp {
+		if filepath.Base(got[i]) != exp[i] {
+			t.Errorf("Expected %v, got %v", exp[i], got[i])
+		}
+	}
+
+	if err := (&snapshotConf{Dir: dir, Schedule: "daily"}).validate(); err == nil {
+		t.Errorf("Expected an invalid schedule to fail validation")
+	}
+}
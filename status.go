@@ -0,0 +1,157 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+var stateNames = map[int]string{
+	normal:     "normal",
+	tooHigh:    "too high",
+	owned:      "owned",
+	aggressive: "aggressive",
+	sprint:     "sprint",
+}
+
+// siteStatus is the latest of what we know about a site, for the
+// dashboard and status API.
+type siteStatus struct {
+	Name        string         `json:"name"`
+	Slug        string         `json:"slug"`
+	URL         string         `json:"url"`
+	Disabled    bool           `json:"disabled"`
+	Threshold   bitcoin.Amount `json:"threshold"`
+	Value       bitcoin.Amount `json:"value"`
+	IsMine      bool           `json:"is_mine"`
+	Locked      bool           `json:"locked"`
+	Pending     string         `json:"pending,omitempty"`
+	Owner       string         `json:"owner,omitempty"`
+	OwnerLink   string         `json:"owner_link,omitempty"`
+	OwnedSince  time.Time      `json:"owned_since"`
+	Contested   string         `json:"contested,omitempty"`
+	State       string         `json:"state"`
+	LastCheck   time.Time      `json:"last_check"`
+	LastSuccess time.Time      `json:"last_success"`
+	// The error from the latest check, if it failed, and when the
+	// latest failure was.
+	LastError string    `json:"last_error,omitempty"`
+	ErrorTime time.Time `json:"error_time"`
+}
+
+// The dashboard shows the balance from our other getbalance calls,
+// only asking bitcoind itself when it's been this long since any.
+const balanceMaxAge = time.Minute
+
+// walletBalance is the latest outcome of a getbalance.
+var walletBalance = struct {
+	sync.Mutex
+	amt     bitcoin.Amount
+	err     error
+	updated time.Time
+}{}
+
+func recordBalance(amt bitcoin.Amount, err error) {
+	walletBalance.Lock()
+	defer walletBalance.Unlock()
+
+	walletBalance.amt, walletBalance.err = amt, err
+	walletBalance.updated = time.Now()
+}
+
+// cachedBalance returns the latest balance seen and when it was seen,
+// going through the health probe for a new one if it's too old.
+func cachedBalance() (bitcoin.Amount, time.Time, error) {
+	walletBalance.Lock()
+	stale := time.Since(walletBalance.updated) > balanceMaxAge
+	walletBalance.Unlock()
+	if stale {
+		if err := checkBitcoind(); err != nil {
+			return 0, time.Time{}, err
+		}
+	}
+
+	walletBalance.Lock()
+	defer walletBalance.Unlock()
+	return walletBalance.amt, walletBalance.updated, walletBalance.err
+}
+
+type statusBoard struct {
+	mu    sync.Mutex
+	sites []*siteStatus
+}
+
+var siteStatuses = &statusBoard{}
+
+func (b *statusBoard) register(s *site) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sites = append(b.sites, &siteStatus{
+		Name:      s.name(),
+		Slug:      s.slug(),
+		URL:       s.ReadURL,
+		Disabled:  s.Disabled,
+		Threshold: s.Threshold,
+		State:     stateNames[normal],
+	})
+}
+
+// Must be called with the lock held.
+func (b *statusBoard) find(u string) *siteStatus {
+	for _, st := range b.sites {
+		if st.URL == u {
+			return st
+		}
+	}
+	return nil
+}
+
+// observed records a successfully parsed state of a site.
+func (b *statusBoard) observed(st State) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ss := b.find(st.Site); ss != nil {
+		ss.Value = st.Value
+		ss.IsMine = st.IsMine
+		ss.Locked = st.Locked
+		ss.Pending = st.Pending
+		ss.LastSuccess = time.Now()
+	}
+}
+
+// checked records the outcome of a check and the polling state it
+// left the site in.
+func (b *statusBoard) checked(s *site, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ss := b.find(s.ReadURL); ss != nil {
+		ss.LastCheck = time.Now()
+		ss.State = stateNames[s.state]
+		if err != nil {
+			ss.LastError = err.Error()
+			ss.ErrorTime = ss.LastCheck
+		} else {
+			ss.LastError = ""
+		}
+	}
+}
+
+func (b *statusBoard) all() []siteStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rv := make([]siteStatus, 0, len(b.sites))
+	for _, st := range b.sites {
+		x := *st
+		if o, ok := owners.current(x.Name); ok {
+			x.Owner, x.OwnerLink, x.OwnedSince = o.Name, o.Link, o.Since
+		}
+		x.Contested = competitors.contested(x.Name)
+		rv = append(rv, x)
+	}
+	return rv
+}
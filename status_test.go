@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+func TestStatusChecked(t *testing.T) {
+	b := &statusBoard{}
+	s := &site{Name: "bears", ReadURL: "http://example.com/"}
+	b.register(s)
+
+	b.checked(s, errors.New("boom"))
+	st := *b.sites[0]
+	if st.LastError != "boom" || st.ErrorTime.IsZero() {
+		t.Errorf("Expected the error to be recorded, got %+v", st)
+	}
+
+	b.checked(s, nil)
+	if st := b.sites[0]; st.LastError != "" || st.ErrorTime.IsZero() {
+		t.Errorf("Expected the error cleared but its time kept, got %+v", st)
+	}
+}
+
+func TestCachedBalance(t *testing.T) {
+	prev := probeBitcoind
+	defer func() {
+		probeBitcoind = prev
+		recordBalance(0, nil)
+		walletBalance.updated = time.Time{}
+	}()
+
+	probes := 0
+	probeBitcoind = func() error {
+		probes++
+		recordBalance(bitcoin.Amount(probes*100), nil)
+		return nil
+	}
+	walletBalance.updated = time.Time{}
+
+	for i := 0; i < 3; i++ {
+		if amt, _, err := cachedBalance(); amt != 100 || err != nil {
+			t.Errorf("Expected the probed balance, got %v/%v", amt, err)
+		}
+	}
+	if probes != 1 {
+		t.Errorf("Expected one probe for repeated loads, got %v", probes)
+	}
+
+	// A buy request's getbalance is just as good.
+	recordBalance(500, nil)
+	if amt, _, _ := cachedBalance(); amt != 500 || probes != 1 {
+		t.Errorf("Expected the recorded balance without probing, got %v after %v probes",
+			amt, probes)
+	}
+
+	walletBalance.updated = time.Now().Add(-2 * balanceMaxAge)
+	if amt, _, _ := cachedBalance(); amt != 200 || probes != 2 {
+		t.Errorf("Expected an old balance to be probed again, got %v after %v probes",
+			amt, probes)
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -23,6 +25,28 @@ var timeFormats = []string{
 	"2006",
 }
 
+// duration is a time.Duration that's configured in JSON as a string
+// such as "90s" or "1h30m".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	x, err := time.ParseDuration(s)
+	*d = duration(x)
+	return err
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d duration) String() string {
+	return time.Duration(d).String()
+}
+
 var unparseableTimestamp = errors.New("unparsable timestamp")
 
 var powTable = []int{
@@ -104,6 +128,84 @@ func parseCanonicalTime(in string) (time.Time, error) {
 }
 
 func parseTime(in string) (time.Time, error) {
+	return parseTimeIn(in, time.UTC, time.Now())
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+type timeStep func(t time.Time, n int) time.Time
+
+// Calendar units Go durations don't have.
+var relativeUnits = map[byte]timeStep{
+	'd': func(t time.Time, n int) time.Time { return t.AddDate(0, 0, n) },
+	'w': func(t time.Time, n int) time.Time { return t.AddDate(0, 0, 7*n) },
+	'y': func(t time.Time, n int) time.Time { return t.AddDate(n, 0, 0) },
+}
+
+var lastUnits = map[string]timeStep{
+	"hour":  func(t time.Time, n int) time.Time { return t.Add(time.Duration(n) * time.Hour) },
+	"day":   relativeUnits['d'],
+	"week":  relativeUnits['w'],
+	"month": func(t time.Time, n int) time.Time { return t.AddDate(0, n, 0) },
+	"year":  relativeUnits['y'],
+}
+
+// parseRelative understands times relative to now:  now, today,
+// yesterday, "last week" and friends, and offsets into the past such
+// as 24h, -90m or 7d (d, w and y count calendar days, weeks and years;
+// anything else is a Go duration).  A leading + goes forward instead.
+func parseRelative(in string, loc *time.Location, now time.Time) (time.Time, bool) {
+	now = now.In(loc)
+	switch in {
+	case "now":
+		return now, true
+	case "today":
+		return startOfDay(now), true
+	case "yesterday":
+		return startOfDay(now).AddDate(0, 0, -1), true
+	}
+
+	if strings.HasPrefix(in, "last ") {
+		if f, ok := lastUnits[strings.TrimSpace(in[5:])]; ok {
+			return f(now, -1), true
+		}
+		return time.Time{}, false
+	}
+
+	if in == "" {
+		return time.Time{}, false
+	}
+	sign := -1
+	switch in[0] {
+	case '+':
+		sign = 1
+		in = in[1:]
+	case '-':
+		in = in[1:]
+	}
+
+	if len(in) > 1 {
+		if f, ok := relativeUnits[in[len(in)-1]]; ok {
+			n, err := strconv.Atoi(in[:len(in)-1])
+			if err == nil {
+				return f(now, sign*n), true
+			}
+		}
+	}
+
+	if d, err := time.ParseDuration(in); err == nil {
+		return now.Add(time.Duration(sign) * d), true
+	}
+	return time.Time{}, false
+}
+
+// parseTimeIn parses absolute or relative times.  Absolute times
+// without a zone are taken to be in loc, and relative ones are
+// relative to now.
+func parseTimeIn(in string, loc *time.Location, now time.Time) (time.Time, error) {
 	// First, try a few numerics
 	n, err := strconv.ParseInt(in, 10, 64)
 	if err == nil {
@@ -123,11 +225,31 @@ func parseTime(in string) (time.Time, error) {
 	if err == nil {
 		return rv, nil
 	}
+	if rv, ok := parseRelative(strings.ToLower(strings.TrimSpace(in)), loc, now); ok {
+		return rv, nil
+	}
 	for _, f := range timeFormats {
-		parsed, err := time.Parse(f, in)
+		parsed, err := time.ParseInLocation(f, in, loc)
 		if err == nil {
 			return parsed, nil
 		}
 	}
 	return time.Time{}, unparseableTimestamp
 }
+
+// parseTimeRange parses start..end where either side may be left
+// off to leave that end open.  Without .. the whole input is the
+// start.
+func parseTimeRange(in string, loc *time.Location, now time.Time) (start, end time.Time, err error) {
+	parts := strings.SplitN(in, "..", 2)
+	if parts[0] != "" {
+		start, err = parseTimeIn(parts[0], loc, now)
+		if err != nil {
+			return
+		}
+	}
+	if len(parts) == 2 && parts[1] != "" {
+		end, err = parseTimeIn(parts[1], loc, now)
+	}
+	return
+}
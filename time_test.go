@@ -60,6 +60,91 @@ func TestTimeParsing(t *testing.T) {
 	}
 }
 
+func TestRelativeTimeParsing(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("No time zone data: %v", err)
+	}
+	now := time.Date(2013, 5, 8, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		input string
+		loc   *time.Location
+		exp   string
+	}{
+		{"now", time.UTC, "2013-05-08T15:30:00Z"},
+		{"today", time.UTC, "2013-05-08T00:00:00Z"},
+		{"Yesterday", time.UTC, "2013-05-07T00:00:00Z"},
+		{"today", ny, "2013-05-08T04:00:00Z"},
+		{"last week", time.UTC, "2013-05-01T15:30:00Z"},
+		{"last month", time.UTC, "2013-04-08T15:30:00Z"},
+		{"-24h", time.UTC, "2013-05-07T15:30:00Z"},
+		{"90m", time.UTC, "2013-05-08T14:00:00Z"},
+		{"7d", time.UTC, "2013-05-01T15:30:00Z"},
+		{"-2w", time.UTC, "2013-04-24T15:30:00Z"},
+		{"+1d", time.UTC, "2013-05-09T15:30:00Z"},
+		{"1y", time.UTC, "2012-05-08T15:30:00Z"},
+		{"2012-08-28", ny, "2012-08-28T04:00:00Z"},
+		{secondAccuracy, ny, secondAccuracy},
+	}
+
+	for _, x := range tests {
+		tm, err := parseTimeIn(x.input, x.loc, now)
+		if err != nil {
+			t.Errorf("Error on %v - %v", x.input, err)
+			continue
+		}
+		got := tm.UTC().Format(time.RFC3339Nano)
+		if x.exp != got {
+			t.Errorf("Expected %v for %v in %v, got %v", x.exp, x.input, x.loc, got)
+		}
+	}
+
+	for _, bad := range []string{"last fortnight", "7x", "-", "soon"} {
+		if tm, err := parseTimeIn(bad, time.UTC, now); err == nil {
+			t.Errorf("Expected error on %q, got %v", bad, tm)
+		}
+	}
+}
+
+func TestTimeRange(t *testing.T) {
+	now := time.Date(2013, 5, 8, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		input      string
+		start, end string
+	}{
+		{"30d..now", "2013-04-08T15:30:00Z", "2013-05-08T15:30:00Z"},
+		{"yesterday..today", "2013-05-07T00:00:00Z", "2013-05-08T00:00:00Z"},
+		{"2013-01-01..", "2013-01-01T00:00:00Z", ""},
+		{"..2013-01-01", "", "2013-01-01T00:00:00Z"},
+		{"today", "2013-05-08T00:00:00Z", ""},
+	}
+
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+
+	for _, x := range tests {
+		start, end, err := parseTimeRange(x.input, time.UTC, now)
+		if err != nil {
+			t.Errorf("Error on %v - %v", x.input, err)
+			continue
+		}
+		if format(start) != x.start || format(end) != x.end {
+			t.Errorf("Expected %v..%v for %v, got %v..%v",
+				x.start, x.end, x.input, format(start), format(end))
+		}
+	}
+
+	if _, _, err := parseTimeRange("bogus..now", time.UTC, now); err == nil {
+		t.Errorf("Expected error on a bad range")
+	}
+}
+
 func TestCanonicalParser(t *testing.T) {
 	tests := []struct {
 		input string
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+const (
+	tradesFile = ",trades.json"
+	// Settled trades beyond this many are moved from the trade log
+	// to an append-only archive next to it.
+	maxTrades = 2000
+)
+
+const (
+	tradeBuy  = "buy"
+	tradeSell = "sell"
+)
+
+// A trade is a purchase or sale of a gem as seen by gembot.  Sales
+// are noticed from the site, so they don't know their txid.
+// Purchases are settled once their payment is confirmed, conflicted
+// or dropped.
+type trade struct {
+	Site    string         `json:"site"`
+	Kind    string         `json:"kind"`
+	Time    time.Time      `json:"time"`
+	Amount  bitcoin.Amount `json:"amount"`
+	TXID    string         `json:"txid,omitempty"`
+	Address string         `json:"address,omitempty"`
+	// The fee paid on a purchase, and the rate we asked for (zero
+	// for the wallet's default).
+	Fee     bitcoin.Amount `json:"fee,omitempty"`
+	FeeRate bitcoin.Amount `json:"fee_rate,omitempty"`
+
+	// How a purchase's payment turned out, and when we found out.
+	Status      string     `json:"status,omitempty"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+}
+
+// unsettled is true of a purchase we haven't seen the end of.
+func (t trade) unsettled() bool {
+	return t.Kind == tradeBuy && t.TXID != "" && t.Status == ""
+}
+
+type tradeLog struct {
+	mu     sync.Mutex
+	fn     string
+	Trades []trade `json:"trades"`
+
+	// Sites by txid and payout address, including archived trades.
+	// The first indexed trades are in the index.
+	byTXID  map[string]string
+	byAddr  map[string]string
+	indexed int
+}
+
+var trades = &tradeLog{}
+
+func (l *tradeLog) load(fn string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.fn = fn
+	l.byTXID = nil
+
+	f, err := os.Open(fn)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error opening trades: %v", err)
+	}
+	defer f.Close()
+
+	d := json.NewDecoder(f)
+	err = d.Decode(l)
+	if err != nil {
+		log.Fatalf("Error decoding trades: %v", err)
+	}
+}
+
+func (l *tradeLog) archiveFile() string {
+	return strings.TrimSuffix(l.fn, ".json") + "-archive.json"
+}
+
+// loadArchive indexes archived trades so old transactions can still
+// be attributed to their sites.  Must be called with the lock held.
+func (l *tradeLog) loadArchive() {
+	l.byTXID, l.byAddr, l.indexed = map[string]string{}, map[string]string{}, 0
+
+	f, err := os.Open(l.archiveFile())
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error opening trade archive: %v", err)
+	}
+	defer f.Close()
+
+	d := json.NewDecoder(f)
+	for {
+		var t trade
+		err := d.Decode(&t)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalf("Error decoding trade archive: %v", err)
+		}
+		l.indexTrade(t)
+	}
+}
+
+// Must be called with the lock held.
+func (l *tradeLog) indexTrade(t trade) {
+	if t.TXID != "" {
+		l.byTXID[t.TXID] = t.Site
+	}
+	if t.Address != "" {
+		l.byAddr[t.Address] = t.Site
+	}
+}
+
+// Must be called with the lock held.
+func (l *tradeLog) updateIndex() {
+	if l.byTXID == nil {
+		if l.fn != "" {
+			l.loadArchive()
+		} else {
+			l.byTXID, l.byAddr, l.indexed = map[string]string{}, map[string]string{}, 0
+		}
+	}
+	for _, t := range l.Trades[l.indexed:] {
+		l.indexTrade(t)
+	}
+	l.indexed = len(l.Trades)
+}
+
+// rotate moves the oldest trades into the archive once there are too
+// many.  Purchases that haven't settled yet are kept, so settle can
+// still find them.  Must be called with the lock held.
+func (l *tradeLog) rotate() {
+	n := len(l.Trades) - maxTrades
+	if n <= 0 || l.fn == "" {
+		return
+	}
+
+	f, err := os.OpenFile(l.archiveFile(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Printf("Error opening trade archive: %v", err)
+		return
+	}
+	defer f.Close()
+
+	var keep []trade
+	e := json.NewEncoder(f)
+	for _, t := range l.Trades[:n] {
+		if t.unsettled() {
+			keep = append(keep, t)
+			continue
+		}
+		if err := e.Encode(t); err != nil {
+			log.Printf("Error archiving trades: %v", err)
+			return
+		}
+	}
+	l.Trades = append(keep, l.Trades[n:]...)
+	l.indexed = len(l.Trades)
+}
+
+func (l *tradeLog) add(t trade) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.Trades = append(l.Trades, t)
+	l.updateIndex()
+	l.rotate()
+	if l.fn != "" {
+		persistState(l.fn, l)
+	}
+}
+
+// siteFor finds the site a trade with the given txid or payout
+// address was made with.
+func (l *tradeLog) siteFor(txid, addr string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.updateIndex()
+	if s, ok := l.byTXID[txid]; ok && txid != "" {
+		return s, true
+	}
+	if s, ok := l.byAddr[addr]; ok && addr != "" {
+		return s, true
+	}
+	return "", false
+}
+
+// recent returns up to n of the most recent trades, newest first.
+func (l *tradeLog) recent(n int) []trade {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rv := []trade{}
+	for i := len(l.Trades) - 1; i >= 0 && len(rv) < n; i-- {
+		rv = append(rv, l.Trades[i])
+	}
+	return rv
+}
+
+// settle records the outcome of the purchase with the given txid.
+func (l *tradeLog) settle(txid, status string, t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	changed := false
+	for i := range l.Trades {
+		tr := &l.Trades[i]
+		if tr.Kind != tradeBuy || tr.TXID != txid {
+			continue
+		}
+		tr.Status = status
+		if status == "confirmed" {
+			tr.ConfirmedAt = &t
+		}
+		changed = true
+	}
+	if changed && l.fn != "" {
+		persistState(l.fn, l)
+	}
+}
+
+// unsettled lists purchases we haven't seen the end of.
+func (l *tradeLog) unsettled() []trade {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var rv []trade
+	for _, t := range l.Trades {
+		if t.unsettled() {
+			rv = append(rv, t)
+		}
+	}
+	return rv
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTradeLogRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trades")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := &tradeLog{}
+	l.load(filepath.Join(dir, "trades.json"))
+	for i := 0; i < maxTrades; i++ {
+		l.Trades = append(l.Trades, trade{
+			Site:   fmt.Sprintf("s%d", i%3),
+			Kind:   tradeBuy,
+			TXID:   fmt.Sprintf("tx%d", i),
+			Status: "confirmed",
+		})
+	}
+	l.Trades[2].Status = ""
+	if s, ok := l.siteFor("tx4", ""); !ok || s != "s1" {
+		t.Errorf("Expected tx4 to be s1, got %v/%v", s, ok)
+	}
+
+	for i := 0; i < 5; i++ {
+		l.add(trade{Site: "new", Kind: tradeSell, Address: fmt.Sprintf("1New%d", i)})
+	}
+	// The unsettled tx2 is kept past the cap.
+	if len(l.Trades) != maxTrades+1 {
+		t.Errorf("Expected the log to be capped at %v, got %v", maxTrades+1, len(l.Trades))
+	}
+	if l.Trades[0].TXID != "tx2" || l.Trades[1].TXID != "tx5" {
+		t.Errorf("Expected the oldest settled trades to be archived, got %+v", l.Trades[:2])
+	}
+
+	l.settle("tx2", "confirmed", time.Now())
+	if l.Trades[0].Status != "confirmed" {
+		t.Errorf("Expected the kept purchase to settle, got %+v", l.Trades[0])
+	}
+	l.add(trade{Site: "new", Kind: tradeSell, Address: "1New5"})
+	if len(l.Trades) != maxTrades || l.Trades[0].TXID != "tx6" {
+		t.Errorf("Expected tx2 archived once settled, got %v trades from %+v",
+			len(l.Trades), l.Trades[0])
+	}
+
+	// A fresh log can still find archived trades.
+	l = &tradeLog{}
+	l.load(filepath.Join(dir, "trades.json"))
+	tests := []struct {
+		txid, addr, exp string
+	}{
+		{"tx0", "", "s0"},
+		{"tx2", "", "s2"},
+		{"tx4", "", "s1"},
+		{"tx1999", "", "s1"},
+		{"", "1New3", "new"},
+		{"nope", "1New4", "new"},
+		{"nope", "nope", ""},
+		{"", "", ""},
+	}
+	for _, test := range tests {
+		s, ok := l.siteFor(test.txid, test.addr)
+		if s != test.exp || ok != (test.exp != "") {
+			t.Errorf("Expected %v/%v to be %q, got %q/%v", test.txid, test.addr, test.exp, s, ok)
+		}
+	}
+}
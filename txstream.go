@@ -0,0 +1,246 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+const txPageSize = 500
+
+// These are variables so tests can stand in for bitcoind.
+var listAccounts = func() (map[string]bitcoin.Amount, error) {
+	defer timeRPC("listaccounts")()
+	return bc.ListAccounts()
+}
+
+var listTransactions = func(acct string, count, from int) ([]bitcoin.Transaction, error) {
+	defer timeRPC("listtransactions")()
+	return bc.ListTransactions(acct, count, from)
+}
+
+// countTransactions finds how many transactions an account has by
+// probing for the first empty offset, since bitcoind doesn't tell us.
+func countTransactions(acct string) (int, error) {
+	exists := func(from int) (bool, error) {
+		txns, err := listTransactions(acct, 1, from)
+		return len(txns) > 0, err
+	}
+
+	lo, hi := 0, 1
+	for {
+		ok, err := exists(hi - 1)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			break
+		}
+		lo, hi = hi, hi*2
+	}
+	// lo transactions definitely exist, hi definitely don't.
+	for lo < hi-1 {
+		mid := (lo + hi) / 2
+		ok, err := exists(mid - 1)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// acctIter walks an account's transactions from oldest to newest a
+// page at a time.  bitcoind offsets count back from the newest
+// transaction, so anything arriving mid-walk pushes the rest of the
+// walk older.  Each page after the first also asks for a page of
+// overlap on the older side, and entries already seen in the
+// previous fetch are skipped.  If a page's worth of transactions
+// arrive between fetches, the walk fails rather than risk skipping
+// any.
+type acctIter struct {
+	acct      string
+	remaining int
+	page      []bitcoin.Transaction
+	prev      map[string]int
+	head      walletTx
+}
+
+var txWalkShifted = errors.New("too many new transactions during the walk, try again")
+
+func txKey(t bitcoin.Transaction) string {
+	return t.TXID + "/" + t.Category + "/" + t.Amount.String()
+}
+
+func (it *acctIter) fill() error {
+	for len(it.page) == 0 && it.remaining > 0 {
+		count := txPageSize
+		if count > it.remaining {
+			count = it.remaining
+		}
+		it.remaining -= count
+		overlap := 0
+		if it.prev != nil {
+			overlap = txPageSize
+		}
+		txns, err := listTransactions(it.acct, count+overlap, it.remaining)
+		if err != nil {
+			return err
+		}
+		if overlap > 0 && len(txns) == count+overlap && it.prev[txKey(txns[0])] == 0 {
+			return txWalkShifted
+		}
+		seen := map[string]int{}
+		for _, t := range txns {
+			k := txKey(t)
+			seen[k]++
+			if it.prev[k] > 0 {
+				it.prev[k]--
+				continue
+			}
+			it.page = append(it.page, t)
+		}
+		it.prev = seen
+	}
+	return nil
+}
+
+// advance moves the next transaction into head, reporting false when
+// the account is exhausted.
+func (it *acctIter) advance() (bool, error) {
+	if err := it.fill(); err != nil || len(it.page) == 0 {
+		return false, err
+	}
+	t := it.page[0]
+	it.page = it.page[1:]
+
+	dir := "out"
+	if t.Amount > 0 {
+		dir = "in"
+	}
+	it.head = walletTx{
+		Time:          t.TransactionTime(),
+		Account:       it.acct,
+		Dir:           dir,
+		Comment:       t.Comment,
+		Confirmations: t.Confirmations,
+		Amount:        t.Amount,
+		Fee:           t.Fee,
+		TXID:          t.TXID,
+		Site:          siteForTransaction(it.acct, t),
+	}
+	return true, nil
+}
+
+type iterHeap []*acctIter
+
+func (h iterHeap) Len() int            { return len(h) }
+func (h iterHeap) Less(i, j int) bool  { return h[i].head.Time.Before(h[j].head.Time) }
+func (h iterHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *iterHeap) Push(x interface{}) { *h = append(*h, x.(*acctIter)) }
+func (h *iterHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// A txStream merges every account's transactions into a single
+// time-ordered stream, holding no more than a page per account in
+// memory.
+type txStream struct {
+	f       txFilter
+	iters   iterHeap
+	skipped int
+	emitted int
+}
+
+func newTxStream(f txFilter) (*txStream, error) {
+	accts, err := listAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &txStream{f: f}
+	for acct := range accts {
+		if f.account != "" && f.account != acct {
+			continue
+		}
+		n, err := countTransactions(acct)
+		if err != nil {
+			return nil, err
+		}
+		it := &acctIter{acct: acct, remaining: n}
+		ok, err := it.advance()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.iters = append(s.iters, it)
+		}
+	}
+	heap.Init(&s.iters)
+
+	return s, nil
+}
+
+// next returns the next matching transaction, or false at the end.
+func (s *txStream) next() (walletTx, bool, error) {
+	for len(s.iters) > 0 {
+		if s.f.limit > 0 && s.emitted >= s.f.limit {
+			return walletTx{}, false, nil
+		}
+
+		it := s.iters[0]
+		t := it.head
+		ok, err := it.advance()
+		if err != nil {
+			return walletTx{}, false, err
+		}
+		if ok {
+			heap.Fix(&s.iters, 0)
+		} else {
+			heap.Pop(&s.iters)
+		}
+
+		if !s.f.before.IsZero() && !t.Time.Before(s.f.before) {
+			// Everything after this is later still.
+			return walletTx{}, false, nil
+		}
+		if !s.f.matches(t) {
+			continue
+		}
+		if s.skipped < s.f.offset {
+			s.skipped++
+			continue
+		}
+		s.emitted++
+		return t, true, nil
+	}
+	return walletTx{}, false, nil
+}
+
+// walletTransactions collects a whole stream for the reports that
+// need to see everything at once.
+func walletTransactions(f txFilter) (txlist, error) {
+	s, err := newTxStream(f)
+	if err != nil {
+		return nil, err
+	}
+	var rv txlist
+	for {
+		t, ok, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return rv, nil
+		}
+		rv = append(rv, t)
+	}
+}
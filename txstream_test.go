@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dustin/go.bitcoin"
+)
+
+// fakeWallet answers listtransactions the way bitcoind does: count
+// transactions, skipping the from most recent, oldest first.
+func fakeWallet(t *testing.T, accts map[string][]bitcoin.Transaction) func() {
+	oldAccts, oldTxns := listAccounts, listTransactions
+	listAccounts = func() (map[string]bitcoin.Amount, error) {
+		rv := map[string]bitcoin.Amount{}
+		for a := range accts {
+			rv[a] = 0
+		}
+		return rv, nil
+	}
+	listTransactions = func(acct string, count, from int) ([]bitcoin.Transaction, error) {
+		txns := accts[acct]
+		end := len(txns) - from
+		if end <= 0 {
+			return nil, nil
+		}
+		start := end - count
+		if start < 0 {
+			start = 0
+		}
+		return txns[start:end], nil
+	}
+	return func() { listAccounts, listTransactions = oldAccts, oldTxns }
+}
+
+func fakeTxns(prefix string, n, offset int, step time.Duration) []bitcoin.Transaction {
+	start := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	var rv []bitcoin.Transaction
+	for i := 0; i < n; i++ {
+		amt := bitcoin.Amount(-1000)
+		if i%2 == 0 {
+			amt = 1000
+		}
+		rv = append(rv, bitcoin.Transaction{
+			TXID:          fmt.Sprintf("%s%d", prefix, i),
+			Amount:        amt,
+			Confirmations: i,
+			Time:          start.Add(time.Duration(offset) + step*time.Duration(i)).Unix(),
+		})
+	}
+	return rv
+}
+
+func TestCountTransactions(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 500, 1234} {
+		defer fakeWallet(t, map[string][]bitcoin.Transaction{
+			"a": fakeTxns("a", n, 0, time.Minute),
+		})()
+		got, err := countTransactions("a")
+		if err != nil || got != n {
+			t.Errorf("Expected %v transactions, got %v/%v", n, got, err)
+		}
+	}
+}
+
+func TestTxStream(t *testing.T) {
+	defer fakeWallet(t, map[string][]bitcoin.Transaction{
+		"a": fakeTxns("a", 1234, 0, 2*time.Minute),
+		"b": fakeTxns("b", 700, int(time.Minute), 2*time.Minute),
+	})()
+
+	all, err := walletTransactions(txFilter{})
+	if err != nil {
+		t.Fatalf("Error streaming: %v", err)
+	}
+	if len(all) != 1934 {
+		t.Fatalf("Expected 1934 transactions, got %v", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Time.Before(all[i-1].Time) {
+			t.Fatalf("Out of order at %v: %v after %v", i, all[i].Time, all[i-1].Time)
+		}
+	}
+	if all[0].TXID != "a0" || all[1].TXID != "b0" || all[2].TXID != "a1" {
+		t.Errorf("Expected accounts to interleave, got %v %v %v",
+			all[0].TXID, all[1].TXID, all[2].TXID)
+	}
+
+	tests := []struct {
+		f     txFilter
+		count int
+		first string
+	}{
+		{txFilter{account: "b"}, 700, "b0"},
+		{txFilter{account: "a", dir: "in"}, 617, "a0"},
+		{txFilter{account: "a", minconf: 1000}, 234, "a1000"},
+		{txFilter{limit: 10, offset: 5}, 10, "b2"},
+		{txFilter{before: all[100].Time}, 100, "a0"},
+	}
+
+	for _, test := range tests {
+		got, err := walletTransactions(test.f)
+		if err != nil {
+			t.Fatalf("Error streaming %+v: %v", test.f, err)
+		}
+		if len(got) != test.count {
+			t.Errorf("Expected %v results for %+v, got %v", test.count, test.f, len(got))
+			continue
+		}
+		if got[0].TXID != test.first {
+			t.Errorf("Expected %+v to start with %v, got %v", test.f, test.first, got[0].TXID)
+		}
+	}
+}
+
+func TestTxStreamArrivals(t *testing.T) {
+	for _, arrivals := range []int{1, 3, txPageSize - 1} {
+		accts := map[string][]bitcoin.Transaction{"a": fakeTxns("a", 1200, 0, time.Minute)}
+		defer fakeWallet(t, accts)()
+
+		// New transactions land in the wallet once the first page
+		// has been read.
+		calls := 0
+		wallet := listTransactions
+		listTransactions = func(acct string, count, from int) ([]bitcoin.Transaction, error) {
+			rv, err := wallet(acct, count, from)
+			if calls++; calls == 1 {
+				accts["a"] = append(accts["a"], fakeTxns("new", arrivals, int(24*time.Hour), time.Minute)...)
+			}
+			return rv, err
+		}
+		it := &acctIter{acct: "a", remaining: 1200}
+
+		got := map[string]bool{}
+		for {
+			ok, err := it.advance()
+			if err != nil {
+				t.Fatalf("Error walking with %v arrivals: %v", arrivals, err)
+			}
+			if !ok {
+				break
+			}
+			if got[it.head.TXID] {
+				t.Errorf("Saw %v twice with %v arrivals", it.head.TXID, arrivals)
+			}
+			got[it.head.TXID] = true
+		}
+		for i := 0; i < 1200; i++ {
+			if id := fmt.Sprintf("a%d", i); !got[id] {
+				t.Errorf("Missing %v with %v arrivals", id, arrivals)
+			}
+		}
+	}
+}
+
+func TestTxStreamTooManyArrivals(t *testing.T) {
+	accts := map[string][]bitcoin.Transaction{"a": fakeTxns("a", 1200, 0, time.Minute)}
+	defer fakeWallet(t, accts)()
+
+	calls := 0
+	wallet := listTransactions
+	listTransactions = func(acct string, count, from int) ([]bitcoin.Transaction, error) {
+		rv, err := wallet(acct, count, from)
+		if calls++; calls == 1 {
+			accts["a"] = append(accts["a"], fakeTxns("new", txPageSize, int(24*time.Hour), time.Minute)...)
+		}
+		return rv, err
+	}
+	it := &acctIter{acct: "a", remaining: 1200}
+
+	for {
+		ok, err := it.advance()
+		if err == txWalkShifted {
+			return
+		}
+		if err != nil || !ok {
+			t.Fatalf("Expected the walk to fail, got %v/%v", ok, err)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	txPollMin      = time.Second
+	txPollMax      = time.Minute
+	txUnknownMax   = 5 * time.Minute
+	txWatchTimeout = 2 * time.Hour
+	// How long finished transactions stay on the status API.
+	txKeepDone = time.Hour
+)
+
+// txConfirmations asks bitcoind how many confirmations a transaction
+// has.  It's a variable so tests can fake it.
+var txConfirmations = func(txid string) (int, error) {
+	done := timeRPC("getrawtransaction")
+	defer done()
+	tx, err := bc.GetRawTransaction(txid)
+	if err != nil {
+		return 0, err
+	}
+	return int(tx.Confirmations), nil
+}
+
+// walletConfirmations asks the wallet about one of our own
+// transactions.  Unlike txConfirmations it can tell us when one has
+// been conflicted (negative confirmations) or dropped (an rpcError).
+var walletConfirmations = func(txid string) (int, error) {
+	var tx struct {
+		Confirmations int `json:"confirmations"`
+	}
+	err := callBitcoind("gettransaction", &tx, txid)
+	return tx.Confirmations, err
+}
+
+// A watchedTx is a transaction we're waiting to see confirmed to
+// Depth, the deepest any of its waiters wants.  Unknown means our node couldn't tell us about it the last time we
+// asked, which is normal for transactions that haven't reached our
+// mempool (or aren't ours, without txindex).
+type watchedTx struct {
+	TXID          string    `json:"txid"`
+	Sites         []string  `json:"sites"`
+	Ours          bool      `json:"ours"`
+	Added         time.Time `json:"added"`
+	LastPoll      time.Time `json:"last_poll"`
+	NextPoll      time.Time `json:"next_poll"`
+	Polls         int       `json:"polls"`
+	Confirmations int       `json:"confirmations"`
+	Depth         int       `json:"depth"`
+	Stuck         bool      `json:"stuck"`
+	Unknown       bool      `json:"unknown"`
+	Error         string    `json:"error,omitempty"`
+	Done          time.Time `json:"done"`
+	Outcome       string    `json:"outcome,omitempty"`
+
+	interval time.Duration
+	waiters  []txWaiter
+}
+
+// A txWaiter is told once a transaction is depth blocks deep.
+type txWaiter struct {
+	ch    chan bool
+	depth int
+}
+
+// release lets go of the waiters whose depth has been reached.
+func (t *watchedTx) release() {
+	var keep []txWaiter
+	for _, w := range t.waiters {
+		if t.Confirmations >= w.depth {
+			close(w.ch)
+		} else {
+			keep = append(keep, w)
+		}
+	}
+	t.waiters = keep
+}
+
+func (t *watchedTx) finish(now time.Time, outcome string) {
+	t.Done = now
+	t.Outcome = outcome
+	for _, w := range t.waiters {
+		close(w.ch)
+	}
+	t.waiters = nil
+}
+
+// txWatcher polls every transaction we care about from one
+// goroutine, backing off for those that aren't moving.
+type txWatcher struct {
+	mu   sync.Mutex
+	txs  map[string]*watchedTx
+	wake chan bool
+}
+
+var txWatch = &txWatcher{txs: map[string]*watchedTx{}, wake: make(chan bool, 1)}
+
+// watch starts (or joins) watching a transaction.  The returned
+// channel is closed once it's confirmed or we give up on it.
+func (w *txWatcher) watch(txid, site string) <-chan bool {
+	return w.add(txid, site, time.Now(), false, 1)
+}
+
+// watchPurchase watches one of our own purchases, sent at the given
+// time, until it's depth blocks deep or has gone wrong.
+func (w *txWatcher) watchPurchase(txid, site string, sent time.Time, depth int) <-chan bool {
+	return w.add(txid, site, sent, true, depth)
+}
+
+func (w *txWatcher) add(txid, site string, added time.Time, ours bool, depth int) <-chan bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ch := make(chan bool)
+	t := w.txs[txid]
+	if t == nil || !t.Done.IsZero() {
+		t = &watchedTx{TXID: txid, Added: added, NextPoll: added,
+			Depth: 1, interval: txPollMin}
+		w.txs[txid] = t
+		log.Printf("Monitoring transaction %v", txid)
+	}
+	if !containsString(t.Sites, site) {
+		t.Sites = append(t.Sites, site)
+	}
+	if ours && !t.Ours {
+		t.Ours = true
+		t.Added = added
+	}
+	if depth > t.Depth {
+		t.Depth = depth
+	}
+	t.waiters = append(t.waiters, txWaiter{ch, depth})
+	t.release()
+
+	select {
+	case w.wake <- true:
+	default:
+	}
+	return ch
+}
+
+// poll checks everything that's due and returns when to next poll.
+func (w *txWatcher) poll(now time.Time) time.Time {
+	w.mu.Lock()
+	var due []*watchedTx
+	for id, t := range w.txs {
+		if !t.Done.IsZero() {
+			if now.Sub(t.Done) > txKeepDone {
+				delete(w.txs, id)
+			}
+			continue
+		}
+		if !t.NextPoll.After(now) {
+			due = append(due, t)
+		}
+	}
+	w.mu.Unlock()
+
+	// Don't hold the lock across RPCs.
+	type result struct {
+		confs int
+		err   error
+	}
+	results := make([]result, len(due))
+	for i, t := range due {
+		lookup := txConfirmations
+		if t.Ours {
+			lookup = walletConfirmations
+		}
+		results[i].confs, results[i].err = lookup(t.TXID)
+	}
+
+	// Things to tell people about once we've let go of the lock.
+	var settled, stuck []watchedTx
+	defer func() {
+		for _, t := range settled {
+			settlePurchase(t)
+		}
+		for _, t := range stuck {
+			alertStuck(t)
+		}
+	}()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, t := range due {
+		t.LastPoll = now
+		t.Polls++
+		confs, err := results[i].confs, results[i].err
+		max := txPollMax
+		progressed := false
+		if e, ok := err.(*rpcError); ok && t.Ours && e.Code == rpcInvalidTxid {
+			log.Printf("Our transaction %v is no longer in the wallet", t.TXID)
+			t.Error = err.Error()
+			t.finish(now, "dropped")
+			settled = append(settled, *t)
+			continue
+		}
+		if err != nil {
+			if !t.Unknown {
+				log.Printf("Can't get transaction %v: %v", t.TXID, err)
+			}
+			t.Unknown = true
+			t.Error = err.Error()
+			max = txUnknownMax
+		} else {
+			progressed = t.Unknown || confs != t.Confirmations
+			t.Unknown = false
+			t.Error = ""
+			t.Confirmations = confs
+			t.release()
+		}
+
+		switch {
+		case t.Confirmations < 0:
+			log.Printf("Our transaction %v was conflicted", t.TXID)
+			t.finish(now, "conflicted")
+			settled = append(settled, *t)
+			continue
+		case t.Confirmations >= t.Depth:
+			log.Printf("Transaction %v confirmed", t.TXID)
+			t.finish(now, "confirmed")
+			if t.Ours {
+				settled = append(settled, *t)
+			}
+			continue
+		case !t.Ours && now.Sub(t.Added) > txWatchTimeout:
+			log.Printf("Timed out monitoring %v", t.TXID)
+			t.finish(now, "timeout")
+			continue
+		case t.Ours && !t.Stuck && t.Confirmations == 0 &&
+			now.Sub(t.Added) > conf.Purchases.stuckAfter():
+			t.Stuck = true
+			stuck = append(stuck, *t)
+		}
+
+		if progressed {
+			t.interval = txPollMin
+		} else {
+			t.interval *= 2
+			if t.interval > max {
+				t.interval = max
+			}
+		}
+		t.NextPoll = now.Add(t.interval)
+	}
+
+	next := now.Add(txPollMax)
+	for _, t := range w.txs {
+		if t.Done.IsZero() && t.NextPoll.Before(next) {
+			next = t.NextPoll
+		}
+	}
+	return next
+}
+
+func (w *txWatcher) run() {
+	for {
+		next := w.poll(time.Now())
+		timer := time.NewTimer(next.Sub(time.Now()))
+		select {
+		case <-timer.C:
+		case <-w.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// all lists watched transactions, newest first.
+func (w *txWatcher) all() []watchedTx {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rv := make([]watchedTx, 0, len(w.txs))
+	for _, t := range w.txs {
+		x := *t
+		x.Sites = append([]string{}, t.Sites...)
+		x.waiters = nil
+		rv = append(rv, x)
+	}
+	sort.Sort(watchedByAdded(rv))
+	return rv
+}
+
+type watchedByAdded []watchedTx
+
+func (w watchedByAdded) Len() int           { return len(w) }
+func (w watchedByAdded) Less(i, j int) bool { return w[i].Added.After(w[j].Added) }
+func (w watchedByAdded) Swap(i, j int)      { w[i], w[j] = w[j], w[i] }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func fakeConfirmations(confs map[string]int) func() {
+	prev, prevWallet := txConfirmations, walletConfirmations
+	txConfirmations = func(txid string) (int, error) {
+		c, ok := confs[txid]
+		if !ok {
+			return 0, errors.New("No information available about transaction")
+		}
+		return c, nil
+	}
+	walletConfirmations = func(txid string) (int, error) {
+		c, ok := confs[txid]
+		if !ok {
+			return 0, &rpcError{rpcInvalidTxid, "Invalid or non-wallet transaction id"}
+		}
+		return c, nil
+	}
+	return func() { txConfirmations, walletConfirmations = prev, prevWallet }
+}
+
+func isClosed(ch <-chan bool) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestTxWatcher(t *testing.T) {
+	confs := map[string]int{"ours": 0}
+	defer fakeConfirmations(confs)()
+
+	w := &txWatcher{txs: map[string]*watchedTx{}, wake: make(chan bool, 1)}
+	ours := w.watchPurchase("ours", "a", time.Now(), 1)
+	theirs := w.watch("theirs", "b")
+	again := w.watch("theirs", "c")
+
+	now := time.Now()
+	next := w.poll(now)
+	if !next.Equal(now.Add(2 * txPollMin)) {
+		t.Errorf("Expected to poll again in %v, got %v", 2*txPollMin, next.Sub(now))
+	}
+
+	// Nothing moving, so we should back off.
+	for i := 0; i < 20; i++ {
+		now = next
+		next = w.poll(now)
+	}
+	all := w.all()
+	if len(all) != 2 {
+		t.Fatalf("Expected two transactions, got %+v", all)
+	}
+	for _, tx := range all {
+		switch tx.TXID {
+		case "ours":
+			if tx.Unknown || !tx.Ours || tx.NextPoll.Sub(tx.LastPoll) != txPollMax {
+				t.Errorf("Expected ours to back off to %v, got %+v", txPollMax, tx)
+			}
+		case "theirs":
+			if !tx.Unknown || len(tx.Sites) != 2 || tx.NextPoll.Sub(tx.LastPoll) != txUnknownMax {
+				t.Errorf("Expected theirs to back off to %v, got %+v", txUnknownMax, tx)
+			}
+		}
+	}
+
+	// Theirs showing up should start polling quickly again.
+	confs["theirs"] = 0
+	now = now.Add(txUnknownMax)
+	next = w.poll(now)
+	if next.Sub(now) != txPollMin {
+		t.Errorf("Expected to poll again in %v, got %v", txPollMin, next.Sub(now))
+	}
+
+	confs["ours"] = 1
+	w.poll(now.Add(txPollMax))
+	if !isClosed(ours) || isClosed(theirs) {
+		t.Errorf("Expected only ours to be done")
+	}
+
+	w.poll(now.Add(txWatchTimeout))
+	if !isClosed(theirs) || !isClosed(again) {
+		t.Errorf("Expected theirs to time out")
+	}
+	for _, tx := range w.all() {
+		exp := map[string]string{"ours": "confirmed", "theirs": "timeout"}[tx.TXID]
+		if tx.Outcome != exp {
+			t.Errorf("Expected %v to be %v, got %+v", tx.TXID, exp, tx)
+		}
+	}
+
+	w.poll(now.Add(txWatchTimeout + txKeepDone + time.Minute))
+	if all := w.all(); len(all) != 0 {
+		t.Errorf("Expected finished transactions to expire, got %+v", all)
+	}
+}
+
+func TestTxWatcherSharedDepth(t *testing.T) {
+	confs := map[string]int{"tx": 0}
+	defer fakeConfirmations(confs)()
+
+	w := &txWatcher{txs: map[string]*watchedTx{}, wake: make(chan bool, 1)}
+	now := time.Now()
+	ours := w.watchPurchase("tx", "a", now, 6)
+	theirs := w.watch("tx", "b")
+
+	confs["tx"] = 1
+	w.poll(now)
+	if isClosed(ours) || !isClosed(theirs) {
+		t.Errorf("Expected only the competitor watch to be done at 1 confirmation")
+	}
+	if late := w.watch("tx", "c"); !isClosed(late) {
+		t.Errorf("Expected a watch joining after its depth to be done")
+	}
+
+	confs["tx"] = 6
+	w.poll(now.Add(txPollMax))
+	if !isClosed(ours) {
+		t.Errorf("Expected our purchase to be done at 6 confirmations")
+	}
+}
+
+func TestPurchaseSettlement(t *testing.T) {
+	confs := map[string]int{"good": 0, "bad": 0, "gone": 0}
+	defer fakeConfirmations(confs)()
+
+	sent := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	prevTrades := trades
+	defer func() { trades = prevTrades }()
+	trades = &tradeLog{Trades: []trade{
+		{Site: "a", Kind: tradeBuy, Time: sent, TXID: "good"},
+		{Site: "b", Kind: tradeBuy, Time: sent, TXID: "bad"},
+		{Site: "c", Kind: tradeBuy, Time: sent, TXID: "gone"},
+		{Site: "d", Kind: tradeBuy, Time: sent, TXID: "old", Status: "confirmed"},
+		{Site: "a", Kind: tradeSell, Time: sent},
+	}}
+
+	w := &txWatcher{txs: map[string]*watchedTx{}, wake: make(chan bool, 1)}
+	for _, tr := range trades.unsettled() {
+		w.watchPurchase(tr.TXID, tr.Site, tr.Time, 3)
+	}
+	if len(w.all()) != 3 {
+		t.Fatalf("Expected three purchases watched, got %+v", w.all())
+	}
+
+	// They've been sent long enough ago to be stuck.
+	now := sent.Add(defaultStuckAfter + time.Minute)
+	w.poll(now)
+	for _, tx := range w.all() {
+		if !tx.Stuck {
+			t.Errorf("Expected %v to be stuck", tx.TXID)
+		}
+	}
+
+	confs["good"] = 2
+	confs["bad"] = -1
+	delete(confs, "gone")
+	now = now.Add(time.Hour)
+	w.poll(now)
+	confs["good"] = 3
+	now = now.Add(time.Hour)
+	w.poll(now)
+
+	exp := map[string]string{"good": "confirmed", "bad": "conflicted", "gone": "dropped", "old": "confirmed"}
+	for _, tr := range trades.Trades {
+		if tr.Kind != tradeBuy {
+			continue
+		}
+		if tr.Status != exp[tr.TXID] {
+			t.Errorf("Expected %v to be %v, got %+v", tr.TXID, exp[tr.TXID], tr)
+		}
+	}
+	if c := trades.Trades[0].ConfirmedAt; c == nil || !c.Equal(now) {
+		t.Errorf("Expected good to be confirmed at %v, got %v", now, c)
+	}
+	if trades.Trades[1].ConfirmedAt != nil {
+		t.Errorf("Didn't expect bad to be confirmed")
+	}
+	if u := trades.unsettled(); len(u) != 0 {
+		t.Errorf("Expected everything settled, got %+v", u)
+	}
+}